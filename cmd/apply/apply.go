@@ -0,0 +1,78 @@
+/*
+Copyright © 2025 Stackroost CLI
+*/
+package apply
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"stackroost-cli/cmd/internal/logger"
+)
+
+// planCmd represents the plan command
+var planCmd = &cobra.Command{
+	Use:   "plan -f [manifest]",
+	Short: "Show what apply would change",
+	Long: `Compare a site manifest with the domains, users and remotes recorded in the
+stackroost config and the live virtual host files, and print the differences.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, err := planFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+		p.print(os.Stdout)
+		return nil
+	},
+}
+
+// applyCmd represents the apply command
+var applyCmd = &cobra.Command{
+	Use:   "apply -f [manifest]",
+	Short: "Converge the system to a site manifest",
+	Long: `Create and update the domains, users and remotes described in a site manifest.
+Entries that exist but are missing from the manifest are only removed with --prune.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, err := planFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+		p.print(os.Stdout)
+		if len(p.changes) == 0 {
+			logger.Success("Nothing to do")
+			return nil
+		}
+		for i, c := range p.changes {
+			logger.Info(fmt.Sprintf("[%d/%d] %s %s %s", i+1, len(p.changes), c.action, c.kind, c.name))
+			if err := c.run(); err != nil {
+				return fmt.Errorf("%s %s %s: %w", c.action, c.kind, c.name, err)
+			}
+		}
+		logger.Success(fmt.Sprintf("Applied %d changes", len(p.changes)))
+		return nil
+	},
+}
+
+func AddApplyCmds(root *cobra.Command) {
+	root.AddCommand(planCmd)
+	root.AddCommand(applyCmd)
+
+	for _, cmd := range []*cobra.Command{planCmd, applyCmd} {
+		cmd.Flags().StringP("file", "f", "", "Site manifest (YAML)")
+		cmd.Flags().Bool("prune", false, "Remove domains, users and remotes that are not in the manifest")
+		cmd.MarkFlagRequired("file")
+	}
+}
+
+func planFromFlags(cmd *cobra.Command) (*plan, error) {
+	file, _ := cmd.Flags().GetString("file")
+	prune, _ := cmd.Flags().GetBool("prune")
+	m, err := loadManifest(file)
+	if err != nil {
+		return nil, err
+	}
+	return buildPlan(m, prune)
+}
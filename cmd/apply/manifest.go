@@ -0,0 +1,103 @@
+/*
+Copyright © 2025 Stackroost CLI
+*/
+package apply
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+	"stackroost-cli/cmd/internal/webserver"
+)
+
+// Manifest is the desired state described in a site.yaml file.
+type Manifest struct {
+	Domains map[string]DomainSpec `yaml:"domains"`
+	Users   map[string]UserSpec   `yaml:"users"`
+	Remotes map[string]RemoteSpec `yaml:"remotes"`
+}
+
+// DomainSpec describes one virtual host.
+type DomainSpec struct {
+	Server  string     `yaml:"server"`
+	Root    string     `yaml:"root"`
+	Aliases []string   `yaml:"aliases"`
+	SSL     *SSLSpec   `yaml:"ssl"`
+	Proxy   *ProxySpec `yaml:"proxy"`
+
+	// proxy is Proxy validated for Server.
+	proxy *webserver.Proxy
+}
+
+// ProxySpec makes the domain a reverse proxy to application servers.
+type ProxySpec struct {
+	Upstreams      []string      `yaml:"upstreams"`
+	Policy         string        `yaml:"policy"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	Timeout        time.Duration `yaml:"timeout"`
+}
+
+// SSLSpec requests a Let's Encrypt certificate for the domain.
+type SSLSpec struct {
+	Email       string   `yaml:"email"`
+	Names       []string `yaml:"names"`
+	Challenge   string   `yaml:"challenge"`
+	DNSProvider string   `yaml:"dns_provider"`
+}
+
+// UserSpec describes a system user managed by stackroost.
+type UserSpec struct {
+	Password string `yaml:"password"`
+	SSH      bool   `yaml:"ssh"`
+}
+
+// RemoteSpec describes a remote server entry.
+type RemoteSpec struct {
+	UserHost string   `yaml:"userhost"`
+	Key      string   `yaml:"key"`
+	Jump     []string `yaml:"jump"`
+	Tags     []string `yaml:"tags"`
+}
+
+// loadManifest reads and validates a manifest, filling in defaults.
+func loadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for name, spec := range m.Domains {
+		if spec.Server == "" {
+			spec.Server = "apache"
+		}
+		if _, err := webserver.Get(spec.Server); err != nil {
+			return nil, fmt.Errorf("domain %s: %w", name, err)
+		}
+		if p := spec.Proxy; p != nil {
+			if spec.Root != "" {
+				return nil, fmt.Errorf("domain %s: root and proxy cannot both be set", name)
+			}
+			if spec.proxy, err = webserver.NewProxy(spec.Server, p.Upstreams, p.Policy, p.ConnectTimeout, p.Timeout); err != nil {
+				return nil, fmt.Errorf("domain %s: %w", name, err)
+			}
+		} else if spec.Root == "" {
+			spec.Root = "/var/www/" + name
+		}
+		m.Domains[name] = spec
+	}
+	for name, spec := range m.Remotes {
+		if spec.UserHost == "" {
+			return nil, fmt.Errorf("remote %s: userhost is required", name)
+		}
+	}
+	return &m, nil
+}
@@ -0,0 +1,285 @@
+/*
+Copyright © 2025 Stackroost CLI
+*/
+package apply
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/viper"
+	"stackroost-cli/cmd/domain"
+	"stackroost-cli/cmd/internal/system"
+	"stackroost-cli/cmd/internal/webserver"
+	"stackroost-cli/cmd/remote"
+	"stackroost-cli/cmd/security"
+)
+
+// change is one step needed to converge the system to the manifest.
+type change struct {
+	action  string // add, update or remove
+	kind    string // domain, user or remote
+	name    string
+	details []string
+	diff    string
+	run     func() error
+}
+
+// plan is the ordered list of changes plus the entries a prune would remove.
+type plan struct {
+	changes []change
+	pruned  []string
+}
+
+func (p *plan) add(c change) {
+	p.changes = append(p.changes, c)
+}
+
+// buildPlan compares the manifest with the config and the live vhost files.
+func buildPlan(m *Manifest, prune bool) (*plan, error) {
+	p := &plan{}
+	var removals []change
+
+	current := domain.Names()
+	for _, name := range sortedKeys(m.Domains) {
+		c, err := planDomain(name, m.Domains[name], slices.Contains(current, name))
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			p.add(*c)
+		}
+	}
+	for _, name := range current {
+		if _, ok := m.Domains[name]; !ok {
+			removals = append(removals, change{action: "remove", kind: "domain", name: name,
+				run: func() error { return domain.Remove(name) }})
+		}
+	}
+
+	managed := security.ManagedUsers()
+	for _, name := range sortedKeys(m.Users) {
+		spec := m.Users[name]
+		switch {
+		case !slices.Contains(managed, name):
+			p.add(change{action: "add", kind: "user", name: name, details: []string{fmt.Sprintf("ssh: %t", spec.SSH)},
+				run: func() error {
+					if err := security.AddUser(name, spec.Password); err != nil {
+						return err
+					}
+					if spec.SSH {
+						return security.SetSSH(name, true)
+					}
+					return nil
+				}})
+		case security.UserSSHEnabled(name) != spec.SSH:
+			p.add(change{action: "update", kind: "user", name: name,
+				details: []string{fmt.Sprintf("ssh: %t -> %t", !spec.SSH, spec.SSH)},
+				run:     func() error { return security.SetSSH(name, spec.SSH) }})
+		}
+	}
+	for _, name := range managed {
+		if _, ok := m.Users[name]; !ok {
+			removals = append(removals, change{action: "remove", kind: "user", name: name,
+				run: func() error { return security.RemoveUser(name) }})
+		}
+	}
+
+	remotes := remote.Names()
+	for _, name := range sortedKeys(m.Remotes) {
+		spec := m.Remotes[name]
+		userHost := viper.GetString("remotes." + name + ".userhost")
+		key := viper.GetString("remotes." + name + ".key")
+		jump := viper.GetStringSlice("remotes." + name + ".jump")
+		tags := remote.Tags(name)
+		run := func() error {
+			return remote.Add(name, remote.Spec{UserHost: spec.UserHost, Key: spec.Key, Jump: spec.Jump, Tags: spec.Tags})
+		}
+		switch {
+		case !slices.Contains(remotes, name):
+			p.add(change{action: "add", kind: "remote", name: name, details: []string{spec.UserHost}, run: run})
+		case userHost != spec.UserHost || key != spec.Key || !slices.Equal(jump, spec.Jump) || !slices.Equal(tags, spec.Tags):
+			var details []string
+			if userHost != spec.UserHost {
+				details = append(details, fmt.Sprintf("userhost: %s -> %s", userHost, spec.UserHost))
+			}
+			if key != spec.Key {
+				details = append(details, fmt.Sprintf("key: %s -> %s", key, spec.Key))
+			}
+			if !slices.Equal(jump, spec.Jump) {
+				details = append(details, fmt.Sprintf("jump: %s -> %s", strings.Join(jump, ","), strings.Join(spec.Jump, ",")))
+			}
+			if !slices.Equal(tags, spec.Tags) {
+				details = append(details, fmt.Sprintf("tags: %s -> %s", strings.Join(tags, ","), strings.Join(spec.Tags, ",")))
+			}
+			p.add(change{action: "update", kind: "remote", name: name, details: details, run: run})
+		}
+	}
+	for _, name := range remotes {
+		if _, ok := m.Remotes[name]; !ok {
+			removals = append(removals, change{action: "remove", kind: "remote", name: name,
+				run: func() error { return remote.Remove(name) }})
+		}
+	}
+
+	for _, c := range removals {
+		if prune {
+			p.add(c)
+		} else {
+			p.pruned = append(p.pruned, c.kind+" "+c.name)
+		}
+	}
+	return p, nil
+}
+
+// planDomain returns the change needed for one domain, or nil when the config
+// and the live vhost already match the spec.
+func planDomain(name string, spec DomainSpec, exists bool) (*change, error) {
+	site := webserver.Site{Domain: name, Root: spec.Root, Aliases: spec.Aliases, Proxy: spec.proxy}
+	issue := func() error {
+		if spec.SSL == nil {
+			return nil
+		}
+		return security.Issue(name, security.IssueOptions{
+			Email:       spec.SSL.Email,
+			Names:       spec.SSL.Names,
+			Challenge:   spec.SSL.Challenge,
+			DNSProvider: spec.SSL.DNSProvider,
+		})
+	}
+
+	if !exists {
+		details := []string{"server: " + spec.Server, "root: " + spec.Root}
+		if spec.proxy != nil {
+			details[1] = "proxy: " + describeProxy(spec.proxy)
+		}
+		if len(spec.Aliases) > 0 {
+			details = append(details, "aliases: "+strings.Join(spec.Aliases, ", "))
+		}
+		if spec.SSL != nil {
+			details = append(details, "ssl: issue certificate")
+		}
+		return &change{action: "add", kind: "domain", name: name, details: details,
+			run: func() error {
+				if err := domain.Create(spec.Server, site); err != nil {
+					return err
+				}
+				return issue()
+			}}, nil
+	}
+
+	ws, cur, err := domain.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if ws.Name() != spec.Server {
+		return &change{action: "update", kind: "domain", name: name,
+			details: []string{fmt.Sprintf("server: %s -> %s (vhost recreated)", ws.Name(), spec.Server)},
+			run: func() error {
+				if err := domain.Remove(name); err != nil {
+					return err
+				}
+				if err := domain.Create(spec.Server, site); err != nil {
+					return err
+				}
+				return issue()
+			}}, nil
+	}
+
+	var details []string
+	if cur.Root != spec.Root && spec.proxy == nil {
+		details = append(details, fmt.Sprintf("root: %s -> %s", cur.Root, spec.Root))
+	}
+	if describeProxy(cur.Proxy) != describeProxy(spec.proxy) {
+		details = append(details, fmt.Sprintf("proxy: %s -> %s", describeProxy(cur.Proxy), describeProxy(spec.proxy)))
+	}
+	if !slices.Equal(cur.Aliases, spec.Aliases) && (len(cur.Aliases) > 0 || len(spec.Aliases) > 0) {
+		details = append(details, fmt.Sprintf("aliases: [%s] -> [%s]", strings.Join(cur.Aliases, ", "), strings.Join(spec.Aliases, ", ")))
+	}
+	needsCert := spec.SSL != nil && cur.CertFile == ""
+	if spec.SSL != nil {
+		site.CertFile, site.KeyFile = cur.CertFile, cur.KeyFile
+	} else if cur.CertFile != "" {
+		details = append(details, "ssl: remove TLS block (certificate is kept)")
+	}
+	if needsCert {
+		details = append(details, "ssl: issue certificate")
+	}
+
+	file := ws.VhostPath(name)
+	live, err := system.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	diff := system.UnifiedDiff(file, file, string(live), domain.Render(ws, site))
+	if diff != "" && len(details) == 0 {
+		details = append(details, "vhost file differs from the generated configuration")
+	}
+	if len(details) == 0 {
+		return nil, nil
+	}
+	return &change{action: "update", kind: "domain", name: name, details: details, diff: diff,
+		run: func() error {
+			if diff != "" || cur.Root != spec.Root || !slices.Equal(cur.Aliases, spec.Aliases) || cur.CertFile != site.CertFile {
+				if err := domain.Update(site); err != nil {
+					return err
+				}
+			}
+			if needsCert {
+				return issue()
+			}
+			return nil
+		}}, nil
+}
+
+// describeProxy summarises a domain's proxy settings for plan details.
+func describeProxy(p *webserver.Proxy) string {
+	if p == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%s (%s, connect timeout %s, timeout %s)", strings.Join(p.Upstreams, ", "), p.Policy, p.ConnectTimeout, p.Timeout)
+}
+
+// print writes the plan in a terraform-like format.
+func (p *plan) print(w io.Writer) {
+	counts := map[string]int{}
+	for _, c := range p.changes {
+		counts[c.action]++
+	}
+	symbols := map[string]*color.Color{
+		"add":    color.New(color.FgGreen, color.Bold),
+		"update": color.New(color.FgYellow, color.Bold),
+		"remove": color.New(color.FgRed, color.Bold),
+	}
+	marks := map[string]string{"add": "+", "update": "~", "remove": "-"}
+	for _, c := range p.changes {
+		symbols[c.action].Fprintf(w, "  %s ", marks[c.action])
+		fmt.Fprintf(w, "%s %s\n", c.kind, c.name)
+		for _, d := range c.details {
+			fmt.Fprintf(w, "      %s\n", d)
+		}
+		if c.diff != "" {
+			for _, line := range strings.Split(strings.TrimRight(c.diff, "\n"), "\n") {
+				fmt.Fprintf(w, "      %s\n", line)
+			}
+		}
+	}
+	if len(p.pruned) > 0 {
+		fmt.Fprintf(w, "\nNot in manifest (kept, pass --prune to remove): %s\n", strings.Join(p.pruned, ", "))
+	}
+	fmt.Fprintf(w, "\nPlan: %d to add, %d to change, %d to remove.\n", counts["add"], counts["update"], counts["remove"])
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
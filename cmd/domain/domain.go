@@ -5,12 +5,13 @@ package domain
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"strings"
 
+	"stackroost-cli/cmd/internal/config"
 	"stackroost-cli/cmd/internal/logger"
-	"stackroost-cli/cmd/internal/utils"
+	"stackroost-cli/cmd/internal/system"
+	"stackroost-cli/cmd/internal/webserver"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -27,23 +28,45 @@ var domainAddCmd = &cobra.Command{
 	Use:   "add [domain]",
 	Short: "Add a new domain",
 	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		domain := args[0]
 		server, _ := cmd.Flags().GetString("server")
+		aliases, _ := cmd.Flags().GetStringSlice("alias")
 		if server == "" {
 			server = "apache" // default
 		}
+		site := webserver.Site{Domain: domain, Aliases: aliases}
+		if upstreams, _ := cmd.Flags().GetStringSlice("proxy"); len(upstreams) > 0 {
+			policy, _ := cmd.Flags().GetString("lb-policy")
+			connectTimeout, _ := cmd.Flags().GetDuration("proxy-connect-timeout")
+			timeout, _ := cmd.Flags().GetDuration("proxy-timeout")
+			proxy, err := webserver.NewProxy(server, upstreams, policy, connectTimeout, timeout)
+			if err != nil {
+				return err
+			}
+			site.Proxy = proxy
+		} else {
+			for _, flag := range []string{"lb-policy", "proxy-connect-timeout", "proxy-timeout"} {
+				if cmd.Flags().Changed(flag) {
+					return fmt.Errorf("--%s needs --proxy", flag)
+				}
+			}
+		}
 		logger.Info(fmt.Sprintf("Domain %s adding for %s", domain, server))
-		createVhost(domain, server)
+		if err := Create(server, site); err != nil {
+			return err
+		}
 		logger.Success(fmt.Sprintf("Domain %s added for %s", domain, server))
+		return nil
 	},
 }
 
 var domainListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List domains",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		listDomains()
+		return nil
 	},
 }
 
@@ -51,11 +74,14 @@ var domainRemoveCmd = &cobra.Command{
 	Use:   "remove [domain]",
 	Short: "Remove a domain",
 	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		domain := args[0]
 		logger.Error(fmt.Sprintf("Domain %s removing", domain))
-		removeVhost(domain)
+		if err := Remove(domain); err != nil {
+			return err
+		}
 		logger.Error(fmt.Sprintf("Domain %s removed", domain))
+		return nil
 	},
 }
 
@@ -63,20 +89,14 @@ var domainEnableCmd = &cobra.Command{
 	Use:   "enable [domain]",
 	Short: "Enable a domain",
 	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		domain := args[0]
-		server := viper.GetString("domains." + domain + ".server")
 		logger.Info(fmt.Sprintf("Domain %s enabling", domain))
-		if server == "apache" {
-			utils.RunCommand("sudo", "a2ensite", domain)
-			utils.RunCommand("sudo", "systemctl", "reload", "apache2")
-		} else if server == "nginx" {
-			utils.RunCommand("sudo", "ln", "-sf", "/etc/nginx/sites-available/"+domain, "/etc/nginx/sites-enabled/"+domain)
-			utils.RunCommand("sudo", "systemctl", "reload", "nginx")
-		} else if server == "caddy" {
-			utils.RunCommand("sudo", "systemctl", "reload", "caddy")
+		if err := Enable(domain); err != nil {
+			return err
 		}
 		logger.Success(fmt.Sprintf("Domain %s enabled", domain))
+		return nil
 	},
 }
 
@@ -84,20 +104,14 @@ var domainDisableCmd = &cobra.Command{
 	Use:   "disable [domain]",
 	Short: "Disable a domain",
 	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		domain := args[0]
-		server := viper.GetString("domains." + domain + ".server")
 		logger.Info(fmt.Sprintf("Domain %s disabling", domain))
-		if server == "apache" {
-			utils.RunCommand("sudo", "a2dissite", domain)
-			utils.RunCommand("sudo", "systemctl", "reload", "apache2")
-		} else if server == "nginx" {
-			utils.RunCommand("sudo", "rm", "-f", "/etc/nginx/sites-enabled/"+domain)
-			utils.RunCommand("sudo", "systemctl", "reload", "nginx")
-		} else if server == "caddy" {
-			utils.RunCommand("sudo", "systemctl", "reload", "caddy")
+		if err := Disable(domain); err != nil {
+			return err
 		}
 		logger.Success(fmt.Sprintf("Domain %s disabled", domain))
+		return nil
 	},
 }
 
@@ -105,12 +119,15 @@ var domainSetRootCmd = &cobra.Command{
 	Use:   "set-root [domain] [path]",
 	Short: "Set document root for a domain",
 	Args:  cobra.ExactArgs(2),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		domain := args[0]
 		root := args[1]
 		logger.Info(fmt.Sprintf("setting document root"))
-		setDocumentRoot(domain, root)
+		if err := SetRoot(domain, root); err != nil {
+			return err
+		}
 		logger.Success(fmt.Sprintf("Set document root for %s to %s\n", domain, root))
+		return nil
 	},
 }
 
@@ -124,115 +141,229 @@ func AddDomainCommands(root *cobra.Command) {
 	domainCmd.AddCommand(domainDisableCmd)
 	domainCmd.AddCommand(domainSetRootCmd)
 
-	domainAddCmd.Flags().String("server", "", "Web server (apache, nginx, caddy)")
+	domainAddCmd.Flags().String("server", "", "Web server ("+strings.Join(webserver.Names(), ", ")+")")
+	domainAddCmd.Flags().StringSlice("alias", nil, "Additional host name served by the domain (repeatable)")
+	domainAddCmd.Flags().StringSlice("proxy", nil, "Proxy requests to this application server, e.g. http://127.0.0.1:3000, instead of serving files (repeatable)")
+	domainAddCmd.Flags().String("lb-policy", webserver.DefaultPolicy, "How requests are spread over several --proxy upstreams ("+strings.Join(webserver.Policies(), ", ")+")")
+	domainAddCmd.Flags().Duration("proxy-connect-timeout", webserver.DefaultConnectTimeout, "Time allowed for connecting to an upstream")
+	domainAddCmd.Flags().Duration("proxy-timeout", webserver.DefaultTimeout, "Time allowed between reads or writes on an upstream connection")
+}
+
+// driverFor returns the web server driver recorded for domain in the config.
+func driverFor(domain string) (webserver.WebServer, error) {
+	server := viper.GetString("domains." + domain + ".server")
+	if server == "" {
+		return nil, fmt.Errorf("domain %s is not managed by stackroost", domain)
+	}
+	return webserver.Get(server)
 }
 
-func createVhost(domain, server string) {
-	root := "/var/www/" + domain
-	os.MkdirAll(root, 0755)
+// Names lists the domains recorded in the config.
+func Names() []string {
+	return config.Names("domains", "server")
+}
 
-	viper.Set("domains."+domain+".server", server)
-	viper.Set("domains."+domain+".root", root)
-	logger.Info(fmt.Sprintf("Writing configuration for domain %s", domain))
-	viper.WriteConfig()
-
-	var content string
-	var file string
-	if server == "apache" {
-		content = fmt.Sprintf(`<VirtualHost *:80>
-    ServerName %s
-    DocumentRoot %s
-    <Directory %s>
-        AllowOverride All
-        Require all granted
-    </Directory>
-</VirtualHost>`, domain, root, root)
-		file = fmt.Sprintf("/etc/apache2/sites-available/%s.conf", domain)
-	} else if server == "nginx" {
-		content = fmt.Sprintf(`server {
-    listen 80;
-    server_name %s;
-    root %s;
-    index index.html index.htm;
-    location / {
-        try_files $uri $uri/ =404;
-    }
-}`, domain, root)
-		file = fmt.Sprintf("/etc/nginx/sites-available/%s", domain)
-	} else if server == "caddy" {
-		content = fmt.Sprintf(`%s {
-    root * %s
-    file_server
-}`, domain, root)
-		file = fmt.Sprintf("/etc/caddy/sites/%s.caddyfile", domain)
-		os.MkdirAll("/etc/caddy/sites", 0755)
-	}
-	ioutil.WriteFile(file, []byte(content), 0644)
+// Lookup returns the driver and site recorded for domain in the config.
+func Lookup(domain string) (webserver.WebServer, webserver.Site, error) {
+	ws, err := driverFor(domain)
+	if err != nil {
+		return nil, webserver.Site{}, err
+	}
+	site := webserver.Site{
+		Domain:   domain,
+		Root:     viper.GetString("domains." + domain + ".root"),
+		Aliases:  viper.GetStringSlice("domains." + domain + ".aliases"),
+		CertFile: viper.GetString("domains." + domain + ".cert"),
+		KeyFile:  viper.GetString("domains." + domain + ".key"),
+	}
+	if upstreams := viper.GetStringSlice("domains." + domain + ".proxy.upstreams"); len(upstreams) > 0 {
+		site.Proxy = &webserver.Proxy{
+			Upstreams:      upstreams,
+			Policy:         viper.GetString("domains." + domain + ".proxy.policy"),
+			ConnectTimeout: viper.GetDuration("domains." + domain + ".proxy.connect_timeout"),
+			Timeout:        viper.GetDuration("domains." + domain + ".proxy.timeout"),
+		}
+	}
+	return ws, site, nil
+}
+
+// Render returns the full virtual host for site, including the TLS block
+// once a certificate has been installed.
+func Render(ws webserver.WebServer, site webserver.Site) string {
+	content := ws.RenderVhost(site)
+	if site.CertFile != "" {
+		content = ws.AddSSL(content, site)
+	}
+	return content
+}
+
+func saveSite(server string, site webserver.Site) error {
+	key := "domains." + site.Domain
+	viper.Set(key+".server", server)
+	viper.Set(key+".root", site.Root)
+	viper.Set(key+".aliases", site.Aliases)
+	viper.Set(key+".cert", site.CertFile)
+	viper.Set(key+".key", site.KeyFile)
+	if p := site.Proxy; p != nil {
+		viper.Set(key+".proxy.upstreams", p.Upstreams)
+		viper.Set(key+".proxy.policy", p.Policy)
+		viper.Set(key+".proxy.connect_timeout", p.ConnectTimeout.String())
+		viper.Set(key+".proxy.timeout", p.Timeout.String())
+	} else if err := config.Unset(key + ".proxy"); err != nil {
+		return err
+	}
+	logger.Info(fmt.Sprintf("Writing configuration for domain %s", site.Domain))
+	return config.Save()
+}
+
+// Create writes the virtual host for a new site and records it in the
+// config. The document root of a site that is not a proxy defaults to
+// /var/www/<domain>.
+func Create(server string, site webserver.Site) error {
+	ws, err := webserver.Get(server)
+	if err != nil {
+		return err
+	}
+	if site.Proxy == nil && site.Root == "" {
+		site.Root = "/var/www/" + site.Domain
+	}
+	if err := system.MkdirAll(site.Webroot(), 0755); err != nil {
+		return err
+	}
+	if err := webserver.Apply(ws, site.Domain, []byte(Render(ws, site))); err != nil {
+		return err
+	}
+	return saveSite(server, site)
+}
+
+// Update regenerates the virtual host of an existing domain from site and
+// reloads the server.
+func Update(site webserver.Site) error {
+	ws, err := driverFor(site.Domain)
+	if err != nil {
+		return err
+	}
+	if err := system.MkdirAll(site.Webroot(), 0755); err != nil {
+		return err
+	}
+	if err := webserver.Apply(ws, site.Domain, []byte(Render(ws, site))); err != nil {
+		return err
+	}
+	if err := ws.Reload(); err != nil {
+		return err
+	}
+	return saveSite(ws.Name(), site)
+}
+
+// InstallCertificate wires an installed certificate into the domain's
+// virtual host and reloads the server.
+func InstallCertificate(domain, certFile, keyFile string) error {
+	ws, site, err := Lookup(domain)
+	if err != nil {
+		return err
+	}
+	logger.Info(fmt.Sprintf("Adding SSL configuration to vhost for domain %s", domain))
+	site.CertFile, site.KeyFile = certFile, keyFile
+	file := ws.VhostPath(domain)
+	content, err := system.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	updated := ws.AddSSL(string(content), site)
+	if updated != string(content) {
+		if err := webserver.Apply(ws, domain, []byte(updated)); err != nil {
+			return err
+		}
+		if err := ws.Reload(); err != nil {
+			return err
+		}
+	}
+	return saveSite(ws.Name(), site)
+}
+
+// Enable makes the domain's virtual host live, leaving it disabled if the
+// server rejects the resulting configuration.
+func Enable(domain string) error {
+	ws, err := driverFor(domain)
+	if err != nil {
+		return err
+	}
+	if err := ws.Enable(domain); err != nil {
+		return err
+	}
+	if err := ws.ConfigTest(); err != nil {
+		if derr := ws.Disable(domain); derr != nil {
+			return fmt.Errorf("%v\ndisabling %s again also failed: %v", err, domain, derr)
+		}
+		return fmt.Errorf("%s rejected the configuration, domain %s left disabled:\n%v", ws.Name(), domain, err)
+	}
+	return ws.Reload()
+}
+
+// Disable takes the domain's virtual host offline without deleting it.
+func Disable(domain string) error {
+	ws, err := driverFor(domain)
+	if err != nil {
+		return err
+	}
+	if err := ws.Disable(domain); err != nil {
+		return err
+	}
+	return ws.Reload()
 }
 
 func listDomains() {
-	domains := viper.GetStringMap("domains")
-	for domain := range domains {
+	for _, domain := range Names() {
 		server := viper.GetString("domains." + domain + ".server")
-		root := viper.GetString("domains." + domain + ".root")
-		logger.Info(fmt.Sprintf("%s (%s) -> %s\n", domain, server, root))
+		target := viper.GetString("domains." + domain + ".root")
+		if upstreams := viper.GetStringSlice("domains." + domain + ".proxy.upstreams"); len(upstreams) > 0 {
+			target = fmt.Sprintf("proxy %s (%s)", strings.Join(upstreams, ", "), viper.GetString("domains."+domain+".proxy.policy"))
+		}
+		logger.Info(fmt.Sprintf("%s (%s) -> %s", domain, server, target))
 	}
 }
 
-func removeVhost(domain string) {
-	server := viper.GetString("domains." + domain + ".server")
-	var file string
-	if server == "apache" {
-		file = fmt.Sprintf("/etc/apache2/sites-available/%s.conf", domain)
-		utils.RunCommand("sudo", "a2dissite", domain)
-	} else if server == "nginx" {
-		file = fmt.Sprintf("/etc/nginx/sites-available/%s", domain)
-		utils.RunCommand("sudo", "rm", "-f", "/etc/nginx/sites-enabled/"+domain)
-	} else if server == "caddy" {
-		file = fmt.Sprintf("/etc/caddy/sites/%s.caddyfile", domain)
-	}
-	os.Remove(file)
+// Remove disables and deletes the domain's virtual host and forgets it.
+func Remove(domain string) error {
+	ws, err := driverFor(domain)
+	if err != nil {
+		return err
+	}
+	if err := ws.Disable(domain); err != nil {
+		return err
+	}
+	if err := system.Remove(ws.VhostPath(domain)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	// Remove from config
 	logger.Info(fmt.Sprintf("Removing configuration for domain %s", domain))
-	viper.Set("domains."+domain, nil)
-	viper.WriteConfig()
+	if err := config.Unset("domains." + domain); err != nil {
+		return err
+	}
+	return config.Save()
 }
 
-func setDocumentRoot(domain, root string) {
-	server := viper.GetString("domains." + domain + ".server")
-	var file string
-	if server == "apache" {
-		file = fmt.Sprintf("/etc/apache2/sites-available/%s.conf", domain)
-		content, _ := ioutil.ReadFile(file)
-		lines := strings.Split(string(content), "\n")
-		for i, line := range lines {
-			if strings.Contains(line, "DocumentRoot") {
-				lines[i] = fmt.Sprintf("    DocumentRoot %s", root)
-			}
-		}
-		ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")), 0644)
-	} else if server == "nginx" {
-		file = fmt.Sprintf("/etc/nginx/sites-available/%s", domain)
-		content, _ := ioutil.ReadFile(file)
-		lines := strings.Split(string(content), "\n")
-		for i, line := range lines {
-			if strings.Contains(line, "root ") {
-				lines[i] = fmt.Sprintf("    root %s;", root)
-			}
-		}
-		ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")), 0644)
-	} else if server == "caddy" {
-		file = fmt.Sprintf("/etc/caddy/sites/%s.caddyfile", domain)
-		content, _ := ioutil.ReadFile(file)
-		lines := strings.Split(string(content), "\n")
-		for i, line := range lines {
-			if strings.Contains(line, "root * ") {
-				lines[i] = fmt.Sprintf("    root * %s", root)
-			}
-		}
-		ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")), 0644)
+// SetRoot points the domain's virtual host at a new document root.
+func SetRoot(domain, root string) error {
+	ws, err := driverFor(domain)
+	if err != nil {
+		return err
+	}
+	if len(viper.GetStringSlice("domains."+domain+".proxy.upstreams")) > 0 {
+		return fmt.Errorf("domain %s is a reverse proxy and has no document root", domain)
+	}
+	file := ws.VhostPath(domain)
+	content, err := system.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := webserver.Apply(ws, domain, []byte(ws.SetRoot(string(content), root))); err != nil {
+		return err
+	}
+	if err := ws.Reload(); err != nil {
+		return err
 	}
 	logger.Info(fmt.Sprintf("Updating configuration for domain %s root to %s", domain, root))
 	viper.Set("domains."+domain+".root", root)
-	viper.WriteConfig()
-}
\ No newline at end of file
+	return config.Save()
+}
@@ -0,0 +1,87 @@
+package acme
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/registration"
+)
+
+// account is the ACME account stackroost registers once per directory and
+// email address. It implements registration.User.
+type account struct {
+	Email        string                 `json:"email"`
+	Registration *registration.Resource `json:"registration"`
+	key          crypto.PrivateKey
+}
+
+func (a *account) GetEmail() string                        { return a.Email }
+func (a *account) GetRegistration() *registration.Resource { return a.Registration }
+func (a *account) GetPrivateKey() crypto.PrivateKey        { return a.key }
+
+// accountDir returns where the account for directory and email is kept, e.g.
+// ~/.stackroost/acme/accounts/acme-v02.api.letsencrypt.org/admin@example.com.
+func accountDir(stateDir, directory, email string) (string, error) {
+	u, err := url.Parse(directory)
+	if err != nil {
+		return "", fmt.Errorf("invalid ACME directory %q: %w", directory, err)
+	}
+	if email == "" {
+		email = "default"
+	}
+	return filepath.Join(stateDir, "accounts", u.Host, email), nil
+}
+
+// loadAccount reads a stored account, creating a new key when there is none.
+// A freshly created account has no registration yet.
+func loadAccount(dir, email string) (*account, error) {
+	keyFile := filepath.Join(dir, "account.key")
+	acc := &account{Email: email}
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if os.IsNotExist(err) {
+		key, err := certcrypto.GeneratePrivateKey(certcrypto.EC256)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(keyFile, certcrypto.PEMEncode(key), 0600); err != nil {
+			return nil, err
+		}
+		acc.key = key
+		return acc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if acc.key, err = certcrypto.ParsePEMPrivateKey(keyPEM); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", keyFile, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "account.json"))
+	if os.IsNotExist(err) {
+		return acc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, acc); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+func (a *account) save(dir string) error {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "account.json"), data, 0600)
+}
@@ -0,0 +1,134 @@
+package acme
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-acme/lego/v4/providers/http/webroot"
+	"github.com/go-acme/lego/v4/registration"
+)
+
+// LetsEncrypt is the default ACME directory.
+const LetsEncrypt = lego.LEDirectoryProduction
+
+// Options configures the ACME client.
+type Options struct {
+	// Directory is the ACME directory URL, e.g. a local Pebble instance.
+	Directory string
+	// Email is used for the account registration and expiry notices.
+	Email string
+	// StateDir holds account keys and registrations.
+	StateDir string
+}
+
+// DefaultStateDir returns ~/.stackroost/acme.
+func DefaultStateDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".stackroost", "acme"), nil
+}
+
+// Client issues, renews and revokes certificates against one ACME directory.
+type Client struct {
+	lego  *lego.Client
+	email string
+}
+
+// NewClient loads or registers the account for opts and returns a client.
+func NewClient(opts Options) (*Client, error) {
+	if opts.Directory == "" {
+		opts.Directory = LetsEncrypt
+	}
+	if opts.StateDir == "" {
+		dir, err := DefaultStateDir()
+		if err != nil {
+			return nil, err
+		}
+		opts.StateDir = dir
+	}
+	dir, err := accountDir(opts.StateDir, opts.Directory, opts.Email)
+	if err != nil {
+		return nil, err
+	}
+	acc, err := loadAccount(dir, opts.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := lego.NewConfig(acc)
+	cfg.CADirURL = opts.Directory
+	cfg.UserAgent = "stackroost-cli"
+	client, err := lego.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", opts.Directory, err)
+	}
+
+	if acc.Registration == nil {
+		reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+		if err != nil {
+			return nil, fmt.Errorf("registering ACME account: %w", err)
+		}
+		acc.Registration = reg
+		if err := acc.save(dir); err != nil {
+			return nil, err
+		}
+	}
+	return &Client{lego: client, email: opts.Email}, nil
+}
+
+// UseWebroot answers HTTP-01 challenges by writing tokens below root, which
+// the web server must serve at /.well-known/acme-challenge/.
+func (c *Client) UseWebroot(root string) error {
+	provider, err := webroot.NewHTTPProvider(root)
+	if err != nil {
+		return err
+	}
+	return c.lego.Challenge.SetHTTP01Provider(provider)
+}
+
+// Obtain requests a new certificate covering domains. The first domain becomes
+// the common name.
+func (c *Client) Obtain(domains []string) (*Certificate, error) {
+	res, err := c.lego.Certificate.Obtain(certificate.ObtainRequest{
+		Domains: domains,
+		Bundle:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.fromResource(res), nil
+}
+
+// Renew requests a fresh certificate for the same names, reusing the key.
+func (c *Client) Renew(cert *Certificate) (*Certificate, error) {
+	res, err := c.lego.Certificate.RenewWithOptions(certificate.Resource{
+		Domain:      cert.Domain,
+		CertURL:     cert.CertURL,
+		Certificate: cert.FullChain,
+		PrivateKey:  cert.PrivateKey,
+	}, &certificate.RenewOptions{Bundle: true})
+	if err != nil {
+		return nil, err
+	}
+	return c.fromResource(res), nil
+}
+
+func (c *Client) fromResource(res *certificate.Resource) *Certificate {
+	return &Certificate{
+		Domain:     res.Domain,
+		Email:      c.email,
+		CertURL:    res.CertURL,
+		FullChain:  res.Certificate,
+		PrivateKey: res.PrivateKey,
+	}
+}
+
+// Revoke tells the CA the certificate must no longer be trusted.
+func (c *Client) Revoke(cert *Certificate) error {
+	return c.lego.Certificate.Revoke(cert.FullChain)
+}
@@ -0,0 +1,114 @@
+package acme
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/providers/dns/exec"
+	"github.com/go-acme/lego/v4/providers/dns/rfc2136"
+)
+
+// DNSProvider publishes and removes the _acme-challenge TXT records used by
+// DNS-01 challenges. It matches lego's challenge.Provider.
+type DNSProvider interface {
+	Present(domain, token, keyAuth string) error
+	CleanUp(domain, token, keyAuth string) error
+}
+
+// DNSFactory builds a provider from its settings, the ssl.dns.<name> block of
+// the config.
+type DNSFactory func(settings map[string]string) (DNSProvider, error)
+
+var dnsProviders = map[string]DNSFactory{
+	"rfc2136": newRFC2136,
+	"exec":    newExecHook,
+}
+
+// RegisterDNSProvider makes a DNS provider available by name.
+func RegisterDNSProvider(name string, factory DNSFactory) {
+	dnsProviders[name] = factory
+}
+
+// NewDNSProvider builds the provider registered under name.
+func NewDNSProvider(name string, settings map[string]string) (DNSProvider, error) {
+	factory, ok := dnsProviders[name]
+	if !ok {
+		names := make([]string, 0, len(dnsProviders))
+		for n := range dnsProviders {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown DNS provider %q (supported: %s)", name, strings.Join(names, ", "))
+	}
+	return factory(settings)
+}
+
+// UseDNS answers DNS-01 challenges through provider. When resolvers are given
+// they are used to check that the TXT record has propagated, which allows
+// testing against a private BIND or Knot server.
+func (c *Client) UseDNS(provider DNSProvider, resolvers []string) error {
+	var opts []dns01.ChallengeOption
+	if len(resolvers) > 0 {
+		opts = append(opts,
+			dns01.AddRecursiveNameservers(dns01.ParseNameservers(resolvers)),
+			dns01.DisableAuthoritativeNssPropagationRequirement())
+	}
+	return c.lego.Challenge.SetDNS01Provider(provider, opts...)
+}
+
+// newRFC2136 sends dynamic updates to an authoritative name server, signed
+// with TSIG when a key is configured.
+func newRFC2136(settings map[string]string) (DNSProvider, error) {
+	cfg := rfc2136.NewDefaultConfig()
+	cfg.Nameserver = settings["nameserver"]
+	if cfg.Nameserver == "" {
+		return nil, fmt.Errorf("rfc2136: ssl.dns.rfc2136.nameserver is required")
+	}
+	cfg.TSIGKey = settings["tsig_key"]
+	cfg.TSIGSecret = settings["tsig_secret"]
+	if alg := settings["tsig_algorithm"]; alg != "" {
+		cfg.TSIGAlgorithm = alg
+	}
+	if ttl := settings["ttl"]; ttl != "" {
+		n, err := strconv.Atoi(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("rfc2136: invalid ttl %q", ttl)
+		}
+		cfg.TTL = n
+	}
+	if err := setTimeout(&cfg.PropagationTimeout, settings["propagation_timeout"]); err != nil {
+		return nil, fmt.Errorf("rfc2136: %w", err)
+	}
+	return rfc2136.NewDNSProviderConfig(cfg)
+}
+
+// newExecHook runs a user supplied program as
+// `program present|cleanup <fqdn> <value>` to manage the TXT record.
+func newExecHook(settings map[string]string) (DNSProvider, error) {
+	cfg := exec.NewDefaultConfig()
+	cfg.Program = settings["program"]
+	if cfg.Program == "" {
+		return nil, fmt.Errorf("exec: ssl.dns.exec.program is required")
+	}
+	cfg.Mode = settings["mode"]
+	if err := setTimeout(&cfg.PropagationTimeout, settings["propagation_timeout"]); err != nil {
+		return nil, fmt.Errorf("exec: %w", err)
+	}
+	return exec.NewDNSProviderConfig(cfg)
+}
+
+func setTimeout(dst *time.Duration, value string) error {
+	if value == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid propagation_timeout %q", value)
+	}
+	*dst = d
+	return nil
+}
@@ -0,0 +1,74 @@
+package acme
+
+import (
+	"encoding/json"
+	"path/filepath"
+
+	"stackroost-cli/cmd/internal/system"
+)
+
+// CertDir is the stackroost-owned tree certificates are installed into, one
+// directory per domain.
+const CertDir = "/etc/stackroost/certs"
+
+// Manual is the Challenge recorded for certificates installed with ssl upload
+// rather than issued over ACME; they cannot be renewed or revoked.
+const Manual = "manual"
+
+// Certificate is an issued certificate with its key.
+type Certificate struct {
+	Domain string `json:"domain"`
+	Email  string `json:"email"` // account the certificate was issued to
+	// Challenge is "http-01", "dns-01" or Manual; DNSProvider names the provider
+	// used for dns-01 so renewals can answer the same way.
+	Challenge   string `json:"challenge"`
+	DNSProvider string `json:"dnsProvider,omitempty"`
+	CertURL     string `json:"certUrl"`
+	FullChain   []byte `json:"-"`
+	PrivateKey  []byte `json:"-"`
+}
+
+// Paths returns the certificate and key files for domain under CertDir.
+func Paths(domain string) (certFile, keyFile string) {
+	dir := filepath.Join(CertDir, domain)
+	return filepath.Join(dir, "fullchain.pem"), filepath.Join(dir, "privkey.pem")
+}
+
+// Save installs the certificate under CertDir.
+func Save(cert *Certificate) error {
+	dir := filepath.Join(CertDir, cert.Domain)
+	if err := system.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	certFile, keyFile := Paths(cert.Domain)
+	meta, err := json.MarshalIndent(cert, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := system.WriteFile(filepath.Join(dir, "cert.json"), meta, 0644); err != nil {
+		return err
+	}
+	if err := system.WriteFile(keyFile, cert.PrivateKey, 0600); err != nil {
+		return err
+	}
+	return system.WriteFile(certFile, cert.FullChain, 0644)
+}
+
+// Load reads a certificate previously installed with Save.
+func Load(domain string) (*Certificate, error) {
+	certFile, keyFile := Paths(domain)
+	cert := &Certificate{Domain: domain}
+	if meta, err := system.ReadFile(filepath.Join(CertDir, domain, "cert.json")); err == nil {
+		if err := json.Unmarshal(meta, cert); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if cert.FullChain, err = system.ReadFile(certFile); err != nil {
+		return nil, err
+	}
+	if cert.PrivateKey, err = system.ReadFile(keyFile); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
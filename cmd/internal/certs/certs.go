@@ -0,0 +1,87 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+)
+
+// Info summarises the leaf certificate of a bundle.
+type Info struct {
+	Path      string    `json:"path"`
+	Domain    string    `json:"domain"`
+	SANs      []string  `json:"sans"`
+	Issuer    string    `json:"issuer"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	DaysLeft  int       `json:"days_remaining"`
+	KeyType   string    `json:"key_type"`
+	KeyBits   int       `json:"key_bits"`
+}
+
+// ParseBundle decodes every CERTIFICATE block in PEM data, in file order.
+func ParseBundle(data []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no PEM certificate found")
+	}
+	return chain, nil
+}
+
+// Describe summarises cert as of now.
+func Describe(path, domain string, cert *x509.Certificate, now time.Time) Info {
+	keyType, bits := PublicKeyInfo(cert.PublicKey)
+	return Info{
+		Path:      path,
+		Domain:    domain,
+		SANs:      cert.DNSNames,
+		Issuer:    issuerName(cert),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		DaysLeft:  int(cert.NotAfter.Sub(now).Hours() / 24),
+		KeyType:   keyType,
+		KeyBits:   bits,
+	}
+}
+
+// PublicKeyInfo returns the algorithm and size of a public key.
+func PublicKeyInfo(key any) (string, int) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return "RSA", k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	}
+	return "unknown", 0
+}
+
+func issuerName(cert *x509.Certificate) string {
+	if cert.Issuer.CommonName == "" {
+		return cert.Issuer.String()
+	}
+	if len(cert.Issuer.Organization) > 0 {
+		return cert.Issuer.Organization[0] + " " + cert.Issuer.CommonName
+	}
+	return cert.Issuer.CommonName
+}
@@ -0,0 +1,110 @@
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ParseKey decodes the first private key in PEM data (PKCS#8, PKCS#1 or SEC 1).
+func ParseKey(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM private key found")
+		}
+		if !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			continue
+		}
+		if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+			if signer, ok := key.(crypto.Signer); ok {
+				return signer, nil
+			}
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+			return key, nil
+		}
+		if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+			return key, nil
+		}
+		return nil, fmt.Errorf("cannot parse %s", block.Type)
+	}
+}
+
+// Validate checks that certPEM and keyPEM form a usable bundle for every name
+// at time now: the key matches a certificate, that certificate covers the
+// names (wildcards included), every other certificate belongs to its issuing
+// chain, and every certificate in the chain is currently valid. It returns the chain ordered from
+// leaf to root, whatever order the bundle was in.
+func Validate(certPEM, keyPEM []byte, names []string, now time.Time) ([]*x509.Certificate, error) {
+	bundle, err := ParseBundle(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("certificate: %w", err)
+	}
+	key, err := ParseKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("private key: %w", err)
+	}
+	leaf := -1
+	for i, cert := range bundle {
+		if pub, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); ok && pub.Equal(key.Public()) {
+			leaf = i
+			break
+		}
+	}
+	if leaf < 0 {
+		return nil, fmt.Errorf("private key does not match any certificate in the bundle")
+	}
+	chain := []*x509.Certificate{bundle[leaf]}
+	rest := append(append([]*x509.Certificate{}, bundle[:leaf]...), bundle[leaf+1:]...)
+	for len(rest) > 0 {
+		next := -1
+		last := chain[len(chain)-1]
+		for i, cert := range rest {
+			if bytes.Equal(last.RawIssuer, cert.RawSubject) && last.CheckSignatureFrom(cert) == nil {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return nil, fmt.Errorf("certificate %q in the bundle does not belong to the chain of %q", rest[0].Subject.CommonName, chain[0].Subject.CommonName)
+		}
+		chain = append(chain, rest[next])
+		rest = append(rest[:next], rest[next+1:]...)
+	}
+	cert := chain[0]
+	for _, name := range names {
+		if err := cert.VerifyHostname(name); err != nil {
+			return nil, fmt.Errorf("certificate does not cover %s (SANs: %s)", name, strings.Join(cert.DNSNames, ", "))
+		}
+	}
+	for i, c := range chain {
+		what := "certificate"
+		if i > 0 {
+			// Clients reject a chain with an expired intermediate too.
+			what = fmt.Sprintf("chain certificate %q", c.Subject.CommonName)
+		}
+		switch {
+		case now.After(c.NotAfter):
+			return nil, fmt.Errorf("%s expired on %s", what, c.NotAfter.Format(time.DateOnly))
+		case now.Before(c.NotBefore):
+			return nil, fmt.Errorf("%s is not valid before %s", what, c.NotBefore.Format(time.DateOnly))
+		}
+	}
+	return chain, nil
+}
+
+// EncodeBundle returns chain as concatenated PEM blocks.
+func EncodeBundle(chain []*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, cert := range chain {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	"go.yaml.in/yaml/v3"
+	"stackroost-cli/cmd/internal/system"
+)
+
+// Path returns the config file in use, falling back to ~/.stackroost.yaml
+// when none has been read yet.
+func Path() (string, error) {
+	if file := viper.ConfigFileUsed(); file != "" {
+		return file, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".stackroost.yaml"), nil
+}
+
+// Save writes the current settings, creating the config file on first use.
+// The file goes through the system package so dry runs only record it.
+func Save() error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := viper.WriteConfigTo(&buf); err != nil {
+		return err
+	}
+	return system.WriteFile(path, buf.Bytes(), 0600)
+}
+
+// Unset removes key and everything below it. Setting a key to nil in viper
+// only hides values set in this process; whatever was read from the config
+// file stays, so the file contents are reloaded without the key.
+func Unset(key string) error {
+	viper.Set(key, nil)
+	settings := viper.AllSettings()
+	if !deletePath(settings, strings.Split(strings.ToLower(key), ".")) {
+		return nil
+	}
+	data, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	viper.SetConfigType("yaml")
+	return viper.ReadConfig(bytes.NewReader(data))
+}
+
+// deletePath removes path from m along with any maps it leaves empty. It
+// reports whether anything was removed.
+func deletePath(m map[string]any, path []string) bool {
+	if len(path) == 1 {
+		_, ok := m[path[0]]
+		delete(m, path[0])
+		return ok
+	}
+	child, ok := m[path[0]].(map[string]any)
+	if !ok || !deletePath(child, path[1:]) {
+		return false
+	}
+	if len(child) == 0 {
+		delete(m, path[0])
+	}
+	return true
+}
+
+// Names lists the entries stored under section, such as the domains or
+// remotes. Viper splits keys on dots, so an entry named example.com ends up
+// nested as example -> com; an entry is recognised by holding the marker
+// field.
+func Names(section, marker string) []string {
+	var names []string
+	var walk func(prefix string, m map[string]any)
+	walk = func(prefix string, m map[string]any) {
+		if _, ok := m[marker]; ok && prefix != "" {
+			names = append(names, prefix)
+		}
+		for key, value := range m {
+			child, ok := value.(map[string]any)
+			if !ok {
+				continue
+			}
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			walk(key, child)
+		}
+	}
+	if m, ok := viper.AllSettings()[section].(map[string]any); ok {
+		walk("", m)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,135 @@
+package distro
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// services maps each supported distribution to the systemd unit of every
+// web server.
+var services = map[string]map[string]string{
+	"ubuntu": {
+		"apache": "apache2",
+		"nginx":  "nginx",
+		"caddy":  "caddy",
+	},
+	"centos": {
+		"apache": "httpd",
+		"nginx":  "nginx",
+		"caddy":  "caddy",
+	},
+	"fedora": {
+		"apache": "httpd",
+		"nginx":  "nginx",
+		"caddy":  "caddy",
+	},
+	"debian": {
+		"apache": "apache2",
+		"nginx":  "nginx",
+		"caddy":  "caddy",
+	},
+	"rhel": {
+		"apache": "httpd",
+		"nginx":  "nginx",
+		"caddy":  "caddy",
+	},
+	"sles": {
+		"apache": "apache2",
+		"nginx":  "nginx",
+		"caddy":  "caddy",
+	},
+	"opensuse": {
+		"apache": "apache2",
+		"nginx":  "nginx",
+		"caddy":  "caddy",
+	},
+}
+
+// Supported reports whether stackroost knows how to manage web servers on
+// distro, an os-release ID such as ubuntu or rhel.
+func Supported(distro string) bool {
+	_, ok := services[distro]
+	return ok
+}
+
+// Services returns the systemd unit of each web server on distro.
+func Services(distro string) (map[string]string, error) {
+	units, ok := services[distro]
+	if !ok {
+		return nil, fmt.Errorf("unsupported distribution: %s", distro)
+	}
+	return units, nil
+}
+
+// Service returns the systemd unit of server on this machine.
+func Service(server string) (string, error) {
+	units, err := Services(Detect())
+	if err != nil {
+		return "", err
+	}
+	unit, ok := units[server]
+	if !ok {
+		return "", fmt.Errorf("unknown server: %s", server)
+	}
+	return unit, nil
+}
+
+// OSRelease holds the fields of /etc/os-release that stackroost uses.
+type OSRelease struct {
+	ID         string
+	IDLike     []string
+	PrettyName string
+}
+
+// ParseOSRelease reads the contents of an os-release file.
+func ParseOSRelease(r io.Reader) OSRelease {
+	var rel OSRelease
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, "\"'")
+		switch key {
+		case "ID":
+			rel.ID = value
+		case "ID_LIKE":
+			rel.IDLike = strings.Fields(value)
+		case "PRETTY_NAME":
+			rel.PrettyName = value
+		}
+	}
+	return rel
+}
+
+// Distro returns the distribution whose service names apply: the ID, or for
+// derivatives such as Rocky Linux or Linux Mint the first supported ID_LIKE
+// entry. It is the ID when neither is supported.
+func (r OSRelease) Distro() string {
+	if Supported(r.ID) {
+		return r.ID
+	}
+	for _, like := range r.IDLike {
+		if Supported(like) {
+			return like
+		}
+	}
+	if r.ID == "" {
+		return "unknown"
+	}
+	return r.ID
+}
+
+// Detect returns the distribution of this machine, or "unknown".
+func Detect() string {
+	file, err := os.Open("/etc/os-release")
+	if err != nil {
+		return "unknown"
+	}
+	defer file.Close()
+	return ParseOSRelease(file).Distro()
+}
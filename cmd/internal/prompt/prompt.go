@@ -0,0 +1,69 @@
+package prompt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// ErrNoTerminal is returned when a question needs an answer but stdin is not
+// a terminal, e.g. in scripts and CI.
+var ErrNoTerminal = errors.New("stdin is not a terminal")
+
+// mu keeps questions asked from concurrent connections from interleaving.
+var mu sync.Mutex
+
+// Interactive reports whether questions can be asked.
+func Interactive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// Confirm asks a yes/no question on the terminal. Anything but y or yes
+// counts as no.
+func Confirm(question string) (bool, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if !Interactive() {
+		return false, ErrNoTerminal
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// Line reads a line of input from the terminal.
+func Line(label string) (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if !Interactive() {
+		return "", ErrNoTerminal
+	}
+	fmt.Fprintf(os.Stderr, "%s: ", label)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(line), err
+}
+
+// Secret reads a line from the terminal without echoing it.
+func Secret(label string) (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if !Interactive() {
+		return "", ErrNoTerminal
+	}
+	fmt.Fprintf(os.Stderr, "%s: ", label)
+	secret, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return string(secret), err
+}
@@ -0,0 +1,165 @@
+package system
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// Step is one action a dry run would have performed.
+type Step struct {
+	Action string // create, modify, remove, mkdir, run or a Simulated action
+	Target string // path or command line
+	Diff   string // unified diff for create and modify
+}
+
+// DryRun records commands and file changes instead of performing them.
+// Reads fall through to the wrapped host but observe the recorded changes,
+// so later steps see the files earlier steps would have written.
+type DryRun struct {
+	base  Host
+	files map[string][]byte // nil content marks a removed file
+	dirs  map[string]bool
+	steps []Step
+}
+
+// NewDryRun returns a DryRun that reads from base.
+func NewDryRun(base Host) *DryRun {
+	return &DryRun{base: base, files: map[string][]byte{}, dirs: map[string]bool{}}
+}
+
+// Steps returns the recorded plan in order.
+func (d *DryRun) Steps() []Step {
+	return d.steps
+}
+
+// PrintPlan writes a human readable plan to w.
+func (d *DryRun) PrintPlan(w io.Writer) {
+	if len(d.steps) == 0 {
+		fmt.Fprintln(w, "Dry run: nothing would be changed.")
+		return
+	}
+	fmt.Fprintln(w, "Dry run: no changes were made. Planned actions:")
+	for i, step := range d.steps {
+		fmt.Fprintf(w, "%3d. %-6s %s\n", i+1, step.Action, step.Target)
+		if step.Diff != "" {
+			for _, line := range strings.Split(strings.TrimRight(step.Diff, "\n"), "\n") {
+				fmt.Fprintf(w, "       %s\n", line)
+			}
+		}
+	}
+}
+
+func (d *DryRun) record(action, target, diff string) {
+	d.steps = append(d.steps, Step{Action: action, Target: target, Diff: diff})
+}
+
+func (d *DryRun) Run(input []byte, name string, args ...string) (*Result, error) {
+	line := strings.Join(append([]string{name}, args...), " ")
+	if input != nil {
+		line += " < (stdin)"
+	}
+	d.record("run", line, "")
+	return &Result{Command: line}, nil
+}
+
+func (d *DryRun) Interactive(name string, args ...string) (*Result, error) {
+	return d.Run(nil, name, args...)
+}
+
+func (d *DryRun) Query(name string, args ...string) (*Result, error) {
+	return d.base.Query(name, args...)
+}
+
+func (d *DryRun) ReadFile(path string) ([]byte, error) {
+	if content, ok := d.files[path]; ok {
+		if content == nil {
+			return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+		}
+		return content, nil
+	}
+	return d.base.ReadFile(path)
+}
+
+func (d *DryRun) WriteFile(path string, data []byte, perm os.FileMode) error {
+	old, err := d.ReadFile(path)
+	action := "modify"
+	if os.IsNotExist(err) {
+		action = "create"
+	} else if err != nil {
+		return err
+	}
+	if action == "modify" && string(old) == string(data) {
+		return nil
+	}
+	from := path
+	if action == "create" {
+		from = "/dev/null"
+	}
+	d.record(action, path, UnifiedDiff(from, path, string(old), string(data)))
+	d.files[path] = append([]byte{}, data...)
+	return nil
+}
+
+func (d *DryRun) MkdirAll(path string, perm os.FileMode) error {
+	if d.dirs[path] {
+		return nil
+	}
+	if info, err := d.base.Stat(path); err == nil && info.IsDir() {
+		return nil
+	}
+	d.dirs[path] = true
+	d.record("mkdir", path, "")
+	return nil
+}
+
+func (d *DryRun) Remove(path string) error {
+	if _, err := d.Stat(path); err != nil {
+		return err
+	}
+	d.files[path] = nil
+	d.record("remove", path, "")
+	return nil
+}
+
+func (d *DryRun) Stat(path string) (os.FileInfo, error) {
+	if content, ok := d.files[path]; ok {
+		if content == nil {
+			return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+		}
+		return plannedFile{path: path, size: int64(len(content))}, nil
+	}
+	if d.dirs[path] {
+		return plannedFile{path: path, dir: true}, nil
+	}
+	return d.base.Stat(path)
+}
+
+// ReadDir lists the directory as it is on the wrapped host; planned files
+// are not included.
+func (d *DryRun) ReadDir(path string) ([]os.DirEntry, error) {
+	return d.base.ReadDir(path)
+}
+
+// plannedFile describes a file or directory that only exists in the plan.
+type plannedFile struct {
+	path string
+	size int64
+	dir  bool
+}
+
+func (f plannedFile) Name() string       { return f.path[strings.LastIndex(f.path, "/")+1:] }
+func (f plannedFile) Size() int64        { return f.size }
+func (f plannedFile) ModTime() time.Time { return time.Time{} }
+func (f plannedFile) IsDir() bool        { return f.dir }
+func (f plannedFile) Sys() any           { return nil }
+
+func (f plannedFile) Mode() os.FileMode {
+	if f.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
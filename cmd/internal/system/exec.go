@@ -0,0 +1,64 @@
+package system
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Result describes a finished command.
+type Result struct {
+	Command  string
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Duration time.Duration
+}
+
+// CommandError is returned when a command cannot be started or exits with a
+// non-zero status.
+type CommandError struct {
+	Result *Result
+	Err    error
+}
+
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("%s: %v", e.Result.Command, e.Err)
+	if stderr := strings.TrimSpace(e.Result.Stderr); stderr != "" {
+		msg += "\n" + stderr
+	}
+	return msg
+}
+
+func (e *CommandError) Unwrap() error { return e.Err }
+
+// ExitCode returns the exit status of the failed command, or 1 when it never
+// started.
+func (e *CommandError) ExitCode() int {
+	if e.Result.ExitCode > 0 {
+		return e.Result.ExitCode
+	}
+	return 1
+}
+
+// Run executes a command on the current host and captures its output.
+func Run(name string, args ...string) (*Result, error) {
+	return current.Run(nil, name, args...)
+}
+
+// RunInput executes a command on the current host with stdin fed from input.
+func RunInput(input []byte, name string, args ...string) (*Result, error) {
+	return current.Run(input, name, args...)
+}
+
+// Interactive executes a command attached to the terminal, for commands such
+// as passwd or tail -f. Output is not captured.
+func Interactive(name string, args ...string) (*Result, error) {
+	return current.Interactive(name, args...)
+}
+
+// Query executes a command that only inspects the system. Unlike Run it is
+// performed even in dry-run mode.
+func Query(name string, args ...string) (*Result, error) {
+	return current.Query(name, args...)
+}
@@ -0,0 +1,80 @@
+package system
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Local performs everything on the machine stackroost runs on.
+type Local struct{}
+
+func (Local) Run(input []byte, name string, args ...string) (*Result, error) {
+	cmd := exec.Command(name, args...)
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	return finish(cmd, func() *Result {
+		return &Result{Stdout: stdout.String(), Stderr: stderr.String()}
+	})
+}
+
+func (Local) Interactive(name string, args ...string) (*Result, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return finish(cmd, func() *Result { return &Result{} })
+}
+
+func (l Local) Query(name string, args ...string) (*Result, error) {
+	return l.Run(nil, name, args...)
+}
+
+func (Local) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func (Local) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(path, data, perm)
+}
+
+func (Local) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (Local) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (Local) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (Local) ReadDir(path string) ([]os.DirEntry, error) {
+	return os.ReadDir(path)
+}
+
+func finish(cmd *exec.Cmd, collect func() *Result) (*Result, error) {
+	start := time.Now()
+	err := cmd.Run()
+	res := collect()
+	res.Command = strings.Join(cmd.Args, " ")
+	res.Duration = time.Since(start)
+	if err == nil {
+		return res, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		res.ExitCode = exitErr.ExitCode()
+	} else {
+		res.ExitCode = -1
+	}
+	return res, &CommandError{Result: res, Err: err}
+}
@@ -0,0 +1,79 @@
+package system
+
+import "os"
+
+// Executor runs commands.
+type Executor interface {
+	Run(input []byte, name string, args ...string) (*Result, error)
+	Interactive(name string, args ...string) (*Result, error)
+	Query(name string, args ...string) (*Result, error)
+}
+
+// FileSystem reads and modifies files.
+type FileSystem interface {
+	ReadFile(path string) ([]byte, error)
+	WriteFile(path string, data []byte, perm os.FileMode) error
+	MkdirAll(path string, perm os.FileMode) error
+	Remove(path string) error
+	Stat(path string) (os.FileInfo, error)
+	ReadDir(path string) ([]os.DirEntry, error)
+}
+
+// Host is a machine that commands and file operations are performed on.
+type Host interface {
+	Executor
+	FileSystem
+}
+
+var current Host = Local{}
+
+// Use makes h the host used by the package level helpers.
+func Use(h Host) {
+	current = h
+}
+
+// Current returns the host used by the package level helpers.
+func Current() Host {
+	return current
+}
+
+// ReadFile reads a file on the current host.
+func ReadFile(path string) ([]byte, error) {
+	return current.ReadFile(path)
+}
+
+// WriteFile writes a file on the current host.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	return current.WriteFile(path, data, perm)
+}
+
+// MkdirAll creates a directory and its parents on the current host.
+func MkdirAll(path string, perm os.FileMode) error {
+	return current.MkdirAll(path, perm)
+}
+
+// Remove deletes a file on the current host.
+func Remove(path string) error {
+	return current.Remove(path)
+}
+
+// Stat describes a file on the current host.
+func Stat(path string) (os.FileInfo, error) {
+	return current.Stat(path)
+}
+
+// ReadDir lists a directory on the current host.
+func ReadDir(path string) ([]os.DirEntry, error) {
+	return current.ReadDir(path)
+}
+
+// Simulated records an action that is neither a command nor a file change,
+// such as a request to an ACME server. It reports whether this is a dry run,
+// in which case the caller must skip the action.
+func Simulated(action, target string) bool {
+	dry, ok := current.(*DryRun)
+	if ok {
+		dry.record(action, target, "")
+	}
+	return ok
+}
@@ -0,0 +1,183 @@
+package webserver
+
+import (
+	"fmt"
+	"strings"
+
+	"stackroost-cli/cmd/internal/distro"
+)
+
+type apache struct{}
+
+func init() {
+	Register(apache{})
+}
+
+// apacheLayout is where an Apache package keeps its files.
+type apacheLayout struct {
+	// sites holds the virtual hosts; enabled ones are linked into enabled.
+	sites, enabled string
+	// a2ensite is set where a2ensite and a2dissite manage the links.
+	a2ensite            bool
+	accessLog, errorLog string
+}
+
+// apacheLayouts maps the Apache unit named in the distro table to its layout.
+// httpd has no sites directories of its own, so enabled vhosts are linked
+// into conf.d, which it loads.
+var apacheLayouts = map[string]apacheLayout{
+	"apache2": {
+		sites:     "/etc/apache2/sites-available",
+		enabled:   "/etc/apache2/sites-enabled",
+		a2ensite:  true,
+		accessLog: "/var/log/apache2/access.log",
+		errorLog:  "/var/log/apache2/error.log",
+	},
+	"httpd": {
+		sites:     "/etc/httpd/sites-available",
+		enabled:   "/etc/httpd/conf.d",
+		accessLog: "/var/log/httpd/access_log",
+		errorLog:  "/var/log/httpd/error_log",
+	},
+}
+
+// apacheService returns the Apache unit of this machine and its layout,
+// falling back to apache2 on distributions the table does not know.
+func apacheService() (string, apacheLayout) {
+	service, err := distro.Service("apache")
+	if _, ok := apacheLayouts[service]; err != nil || !ok {
+		service = "apache2"
+	}
+	return service, apacheLayouts[service]
+}
+
+func (apache) Name() string { return "apache" }
+
+func (apache) VhostPath(domain string) string {
+	_, layout := apacheService()
+	return fmt.Sprintf("%s/%s.conf", layout.sites, domain)
+}
+
+func (apache) RenderVhost(site Site) string {
+	if site.Proxy != nil {
+		return fmt.Sprintf(`<VirtualHost *:80>
+    ServerName %s
+%s%s</VirtualHost>`, site.Domain, apacheAliases(site), apacheProxy(site, "http"))
+	}
+	return fmt.Sprintf(`<VirtualHost *:80>
+    ServerName %s
+%s    DocumentRoot %s
+    <Directory %s>
+        AllowOverride All
+        Require all granted
+    </Directory>
+</VirtualHost>`, site.Domain, apacheAliases(site), site.Root, site.Root)
+}
+
+func apacheAliases(site Site) string {
+	if len(site.Aliases) == 0 {
+		return ""
+	}
+	return "    ServerAlias " + strings.Join(site.Aliases, " ") + "\n"
+}
+
+// apacheProxy returns the directives balancing requests over the site's
+// application servers. mod_proxy adds X-Forwarded-For and X-Forwarded-Host
+// itself; the proto header needs mod_headers, and upgrade=websocket Apache
+// 2.4.47 or later. proxy, proxy_http, proxy_balancer, the lbmethod module and
+// headers must be enabled. ACME challenges are excluded from the proxy and
+// served from ChallengeRoot.
+func apacheProxy(site Site, proto string) string {
+	p, id := site.Proxy, identifier(site.Domain)
+	var b strings.Builder
+	b.WriteString("    ProxyRequests Off\n    ProxyPreserveHost On\n")
+	if p.Scheme() == "https" {
+		b.WriteString("    SSLProxyEngine on\n")
+	}
+	fmt.Fprintf(&b, "    RequestHeader set X-Forwarded-Proto \"%s\"\n", proto)
+	fmt.Fprintf(&b, "    <Proxy \"balancer://%s\">\n", id)
+	for _, upstream := range p.Upstreams {
+		fmt.Fprintf(&b, "        BalancerMember \"%s\" connectiontimeout=%d timeout=%d upgrade=websocket\n",
+			upstream, seconds(p.ConnectTimeout), seconds(p.Timeout))
+	}
+	fmt.Fprintf(&b, "        ProxySet lbmethod=%s\n    </Proxy>\n", p.policy("apache"))
+	fmt.Fprintf(&b, "    Alias \"%s\" \"%s%s\"\n", challengePath, ChallengeRoot, challengePath)
+	fmt.Fprintf(&b, "    <Directory \"%s\">\n        Require all granted\n    </Directory>\n", ChallengeRoot)
+	fmt.Fprintf(&b, "    ProxyPass \"%s\" !\n", challengePath)
+	fmt.Fprintf(&b, "    ProxyPass \"/\" \"balancer://%s/\"\n", id)
+	fmt.Fprintf(&b, "    ProxyPassReverse \"/\" \"balancer://%s/\"\n", id)
+	return b.String()
+}
+
+func (apache) SetRoot(config, root string) string {
+	config = replaceDirective(config, "DocumentRoot", "DocumentRoot "+root)
+	return replaceDirective(config, "<Directory ", fmt.Sprintf("<Directory %s>", root))
+}
+
+// AddSSL appends a port 443 virtual host using the site's certificate, or
+// points an existing one at the new files. mod_ssl must be enabled.
+func (apache) AddSSL(config string, site Site) string {
+	if strings.Contains(config, "SSLCertificateFile") {
+		config = replaceDirective(config, "SSLCertificateFile ", "SSLCertificateFile "+site.CertFile)
+		return replaceDirective(config, "SSLCertificateKeyFile ", "SSLCertificateKeyFile "+site.KeyFile)
+	}
+	if site.Proxy != nil {
+		return config + fmt.Sprintf(`
+<VirtualHost *:443>
+    ServerName %s
+%s    SSLEngine on
+    SSLCertificateFile %s
+    SSLCertificateKeyFile %s
+%s</VirtualHost>`, site.Domain, apacheAliases(site), site.CertFile, site.KeyFile, apacheProxy(site, "https"))
+	}
+	return config + fmt.Sprintf(`
+<VirtualHost *:443>
+    ServerName %s
+%s    DocumentRoot %s
+    SSLEngine on
+    SSLCertificateFile %s
+    SSLCertificateKeyFile %s
+    <Directory %s>
+        AllowOverride All
+        Require all granted
+    </Directory>
+</VirtualHost>`, site.Domain, apacheAliases(site), site.Root, site.CertFile, site.KeyFile, site.Root)
+}
+
+func (a apache) Enable(domain string) error {
+	_, layout := apacheService()
+	if layout.a2ensite {
+		return run("sudo", "a2ensite", domain)
+	}
+	return run("sudo", "ln", "-sf", a.VhostPath(domain), fmt.Sprintf("%s/%s.conf", layout.enabled, domain))
+}
+
+func (apache) Disable(domain string) error {
+	_, layout := apacheService()
+	if layout.a2ensite {
+		return run("sudo", "a2dissite", domain)
+	}
+	return run("sudo", "rm", "-f", fmt.Sprintf("%s/%s.conf", layout.enabled, domain))
+}
+
+func (apache) Enabled(domain string) (bool, error) {
+	_, layout := apacheService()
+	return linked(fmt.Sprintf("%s/%s.conf", layout.enabled, domain))
+}
+
+func (apache) ConfigTest() error {
+	return run("sudo", "apachectl", "configtest")
+}
+
+func (apache) Reload() error {
+	service, _ := apacheService()
+	return run("sudo", "systemctl", "reload", service)
+}
+
+func (apache) LogFile(logType string) string {
+	_, layout := apacheService()
+	if logType == "error" {
+		return layout.errorLog
+	}
+	return layout.accessLog
+}
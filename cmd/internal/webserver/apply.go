@@ -0,0 +1,90 @@
+package webserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"stackroost-cli/cmd/internal/system"
+)
+
+// snapshot holds the contents of a config file before it was modified.
+type snapshot struct {
+	path    string
+	content []byte
+	mode    os.FileMode
+	existed bool
+}
+
+func takeSnapshot(path string) (*snapshot, error) {
+	s := &snapshot{path: path, mode: 0644}
+	info, err := system.Stat(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	content, err := system.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s.content, s.mode, s.existed = content, info.Mode().Perm(), true
+	return s, nil
+}
+
+// restore puts the file back the way it was when the snapshot was taken.
+func (s *snapshot) restore() error {
+	if !s.existed {
+		return system.Remove(s.path)
+	}
+	return system.WriteFile(s.path, s.content, s.mode)
+}
+
+// Apply writes content to the virtual host of domain and runs the server's
+// syntax check. When the check fails the previous file is restored and the
+// checker output is returned, so callers only reload once Apply succeeds.
+func Apply(ws WebServer, domain string, content []byte) error {
+	path := ws.VhostPath(domain)
+	snap, err := takeSnapshot(path)
+	if err != nil {
+		return err
+	}
+	enabled, err := ws.Enabled(domain)
+	if err != nil {
+		return err
+	}
+	if err := system.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := system.WriteFile(path, content, snap.mode); err != nil {
+		return err
+	}
+	if err := check(ws, domain, enabled); err != nil {
+		if rerr := snap.restore(); rerr != nil {
+			return fmt.Errorf("%v\nrestoring %s also failed: %v", err, path, rerr)
+		}
+		return fmt.Errorf("%s rejected the new configuration, restored %s:\n%v", ws.Name(), path, err)
+	}
+	return nil
+}
+
+// check runs the server's syntax check with the virtual host of domain
+// loaded. Servers skip disabled virtual hosts, so one that is not enabled is
+// enabled for the check and disabled again afterwards.
+func check(ws WebServer, domain string, enabled bool) error {
+	if enabled {
+		return ws.ConfigTest()
+	}
+	if err := ws.Enable(domain); err != nil {
+		return err
+	}
+	err := ws.ConfigTest()
+	if derr := ws.Disable(domain); derr != nil {
+		if err != nil {
+			return fmt.Errorf("%v\ndisabling %s again also failed: %v", err, domain, derr)
+		}
+		return derr
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package webserver
+
+import (
+	"fmt"
+	"strings"
+)
+
+type caddy struct{}
+
+func init() {
+	Register(caddy{})
+}
+
+func (caddy) Name() string { return "caddy" }
+
+func (caddy) VhostPath(domain string) string {
+	return fmt.Sprintf("/etc/caddy/sites/%s.caddyfile", domain)
+}
+
+// RenderVhost relies on reverse_proxy for proxy sites to pass WebSocket
+// upgrades and set the X-Forwarded-* headers, which it does by default. ACME
+// challenges for certificates stackroost issues are answered from
+// ChallengeRoot ahead of the proxy.
+func (caddy) RenderVhost(site Site) string {
+	if p := site.Proxy; p != nil {
+		return fmt.Sprintf(`%s {
+    handle_path %s* {
+        root * %s%s
+        file_server
+    }
+    reverse_proxy %s {
+        lb_policy %s
+        transport http {
+            dial_timeout %ds
+            read_timeout %ds
+            write_timeout %ds
+        }
+    }
+}`, strings.Join(site.hostNames(), ", "), challengePath, ChallengeRoot, challengePath,
+			strings.Join(p.Upstreams, " "), p.policy("caddy"),
+			seconds(p.ConnectTimeout), seconds(p.Timeout), seconds(p.Timeout))
+	}
+	return fmt.Sprintf(`%s {
+    root * %s
+    file_server
+}`, strings.Join(site.hostNames(), ", "), site.Root)
+}
+
+func (caddy) SetRoot(config, root string) string {
+	return replaceDirective(config, "root * ", "root * "+root)
+}
+
+// AddSSL makes Caddy serve the site's certificate instead of provisioning
+// one itself.
+func (caddy) AddSSL(config string, site Site) string {
+	directive := fmt.Sprintf("tls %s %s", site.CertFile, site.KeyFile)
+	if strings.Contains(config, "    tls ") {
+		return replaceDirective(config, "    tls ", directive)
+	}
+	end := strings.LastIndex(config, "}")
+	if end < 0 {
+		return config
+	}
+	return config[:end] + "    " + directive + "\n" + config[end:]
+}
+
+// Enable and Disable are no-ops: every file under /etc/caddy/sites is
+// imported by the main Caddyfile.
+func (caddy) Enable(domain string) error { return nil }
+
+func (caddy) Disable(domain string) error { return nil }
+
+func (caddy) Enabled(domain string) (bool, error) { return true, nil }
+
+func (caddy) ConfigTest() error {
+	return run("sudo", "caddy", "validate", "--config", "/etc/caddy/Caddyfile")
+}
+
+func (caddy) Reload() error {
+	return run("sudo", "systemctl", "reload", "caddy")
+}
+
+// LogFile returns the same file for both log types; Caddy is assumed to log
+// to /var/log/caddy.log rather than the journal.
+func (caddy) LogFile(logType string) string {
+	return "/var/log/caddy.log"
+}
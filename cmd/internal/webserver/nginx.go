@@ -0,0 +1,149 @@
+package webserver
+
+import (
+	"fmt"
+	"strings"
+)
+
+type nginx struct{}
+
+func init() {
+	Register(nginx{})
+}
+
+func (nginx) Name() string { return "nginx" }
+
+func (nginx) VhostPath(domain string) string {
+	return fmt.Sprintf("/etc/nginx/sites-available/%s", domain)
+}
+
+func (nginx) RenderVhost(site Site) string {
+	if site.Proxy != nil {
+		return nginxUpstream(site) + fmt.Sprintf(`server {
+    listen 80;
+    server_name %s;
+%s}`, strings.Join(site.hostNames(), " "), nginxProxyLocation(site))
+	}
+	return fmt.Sprintf(`server {
+    listen 80;
+    server_name %s;
+    root %s;
+    index index.html index.htm;
+    location / {
+        try_files $uri $uri/ =404;
+    }
+}`, strings.Join(site.hostNames(), " "), site.Root)
+}
+
+func (nginx) SetRoot(config, root string) string {
+	return replaceDirective(config, "root ", fmt.Sprintf("root %s;", root))
+}
+
+// AddSSL appends a port 443 server block using the site's certificate, or
+// points an existing one at the new files.
+func (nginx) AddSSL(config string, site Site) string {
+	if strings.Contains(config, "ssl_certificate ") {
+		config = replaceDirective(config, "ssl_certificate ", fmt.Sprintf("ssl_certificate %s;", site.CertFile))
+		return replaceDirective(config, "ssl_certificate_key ", fmt.Sprintf("ssl_certificate_key %s;", site.KeyFile))
+	}
+	if site.Proxy != nil {
+		return config + fmt.Sprintf(`
+server {
+    listen 443 ssl;
+    server_name %s;
+    ssl_certificate %s;
+    ssl_certificate_key %s;
+%s}`, strings.Join(site.hostNames(), " "), site.CertFile, site.KeyFile, nginxProxyLocation(site))
+	}
+	return config + fmt.Sprintf(`
+server {
+    listen 443 ssl;
+    server_name %s;
+    root %s;
+    ssl_certificate %s;
+    ssl_certificate_key %s;
+    location / {
+        try_files $uri $uri/ =404;
+    }
+}`, strings.Join(site.hostNames(), " "), site.Root, site.CertFile, site.KeyFile)
+}
+
+// nginxUpstream returns the upstream block listing the site's application
+// servers and the map that sets Connection for WebSocket upgrades. Both are
+// named after the domain, since every site shares the http context.
+func nginxUpstream(site Site) string {
+	id := identifier(site.Domain)
+	var b strings.Builder
+	fmt.Fprintf(&b, "upstream %s_upstream {\n", id)
+	if policy := site.Proxy.policy("nginx"); policy != "" {
+		fmt.Fprintf(&b, "    %s;\n", policy)
+	}
+	for _, host := range site.Proxy.hostPorts() {
+		fmt.Fprintf(&b, "    server %s;\n", host)
+	}
+	fmt.Fprintf(&b, `}
+
+map $http_upgrade $%s_connection {
+    default upgrade;
+    ''      close;
+}
+
+`, id)
+	return b.String()
+}
+
+// nginxProxyLocation returns the location block forwarding every request to
+// the site's upstream, passing on upgrades and the client's address. ACME
+// challenges are answered from ChallengeRoot instead.
+func nginxProxyLocation(site Site) string {
+	p, id := site.Proxy, identifier(site.Domain)
+	tls := ""
+	if p.Scheme() == "https" {
+		tls = "        proxy_ssl_server_name on;\n"
+	}
+	return fmt.Sprintf(`    location ^~ %s {
+        root %s;
+    }
+    location / {
+        proxy_pass %s://%s_upstream;
+%s        proxy_http_version 1.1;
+        proxy_set_header Host $host;
+        proxy_set_header Upgrade $http_upgrade;
+        proxy_set_header Connection $%s_connection;
+        proxy_set_header X-Real-IP $remote_addr;
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        proxy_set_header X-Forwarded-Proto $scheme;
+        proxy_set_header X-Forwarded-Host $host;
+        proxy_connect_timeout %ds;
+        proxy_send_timeout %ds;
+        proxy_read_timeout %ds;
+    }
+`, challengePath, ChallengeRoot, p.Scheme(), id, tls, id, seconds(p.ConnectTimeout), seconds(p.Timeout), seconds(p.Timeout))
+}
+
+func (nginx) Enable(domain string) error {
+	return run("sudo", "ln", "-sf", "/etc/nginx/sites-available/"+domain, "/etc/nginx/sites-enabled/"+domain)
+}
+
+func (nginx) Disable(domain string) error {
+	return run("sudo", "rm", "-f", "/etc/nginx/sites-enabled/"+domain)
+}
+
+func (nginx) Enabled(domain string) (bool, error) {
+	return linked("/etc/nginx/sites-enabled/" + domain)
+}
+
+func (nginx) ConfigTest() error {
+	return run("sudo", "nginx", "-t")
+}
+
+func (nginx) Reload() error {
+	return run("sudo", "systemctl", "reload", "nginx")
+}
+
+func (nginx) LogFile(logType string) string {
+	if logType == "error" {
+		return "/var/log/nginx/error.log"
+	}
+	return "/var/log/nginx/access.log"
+}
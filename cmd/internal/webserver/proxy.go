@@ -0,0 +1,166 @@
+package webserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Proxy describes the application servers a reverse-proxy site forwards to
+// in place of serving files from a document root.
+type Proxy struct {
+	// Upstreams are normalised scheme://host:port URLs sharing one scheme.
+	Upstreams []string
+	// Policy picks the upstream for each request; see Policies.
+	Policy string
+	// ConnectTimeout bounds connecting to an upstream, Timeout each read
+	// from or write to it once connected.
+	ConnectTimeout time.Duration
+	Timeout        time.Duration
+}
+
+// Default proxy settings used when none are given.
+const (
+	DefaultPolicy         = "round_robin"
+	DefaultConnectTimeout = 5 * time.Second
+	DefaultTimeout        = 60 * time.Second
+)
+
+// policies maps each load-balancing policy to the directive value of every
+// server that supports it.
+var policies = map[string]map[string]string{
+	"round_robin": {"apache": "byrequests", "nginx": "", "caddy": "round_robin"},
+	"least_conn":  {"apache": "bybusyness", "nginx": "least_conn", "caddy": "least_conn"},
+	"ip_hash":     {"nginx": "ip_hash", "caddy": "ip_hash"},
+}
+
+// ChallengeRoot is the webroot proxy sites answer ACME HTTP-01 challenges
+// from, since they have no document root of their own.
+const ChallengeRoot = "/var/lib/stackroost/acme-challenge"
+
+// challengePath is where ACME HTTP-01 challenge files are fetched from.
+const challengePath = "/.well-known/acme-challenge/"
+
+// Webroot returns the directory the site serves ACME challenge files from:
+// its document root, or ChallengeRoot for a proxy.
+func (s Site) Webroot() string {
+	if s.Proxy != nil {
+		return ChallengeRoot
+	}
+	return s.Root
+}
+
+// Policies lists the load-balancing policies in a stable order.
+func Policies() []string {
+	return []string{"round_robin", "least_conn", "ip_hash"}
+}
+
+// NewProxy validates upstreams and policy for server and returns the proxy
+// with defaults filled in. An upstream without a scheme is taken to be
+// plain HTTP.
+func NewProxy(server string, upstreams []string, policy string, connectTimeout, timeout time.Duration) (*Proxy, error) {
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("a proxy needs at least one upstream")
+	}
+	p := &Proxy{Policy: policy, ConnectTimeout: connectTimeout, Timeout: timeout}
+	if p.Policy == "" {
+		p.Policy = DefaultPolicy
+	}
+	byServer, ok := policies[p.Policy]
+	if !ok {
+		return nil, fmt.Errorf("unknown load-balancing policy %q (supported: %s)", p.Policy, strings.Join(Policies(), ", "))
+	}
+	if _, ok := byServer[server]; !ok {
+		return nil, fmt.Errorf("%s does not support the %s load-balancing policy", server, p.Policy)
+	}
+	if p.ConnectTimeout <= 0 {
+		p.ConnectTimeout = DefaultConnectTimeout
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = DefaultTimeout
+	}
+	for _, upstream := range upstreams {
+		normalised, err := parseUpstream(upstream)
+		if err != nil {
+			return nil, err
+		}
+		p.Upstreams = append(p.Upstreams, normalised)
+	}
+	for _, u := range p.Upstreams[1:] {
+		if p.scheme(u) != p.Scheme() {
+			return nil, fmt.Errorf("upstreams must share one scheme, but %s is not %s", u, p.Scheme())
+		}
+	}
+	return p, nil
+}
+
+// parseUpstream checks an upstream URL and returns it as scheme://host:port.
+// Upstreams cannot carry a path, since requests keep their own.
+func parseUpstream(upstream string) (string, error) {
+	raw := upstream
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("invalid upstream %q, expected http(s)://host[:port]", upstream)
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.User != nil {
+		return "", fmt.Errorf("upstream %q cannot have a path, query or credentials", upstream)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return u.Scheme + "://" + net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// Scheme returns the scheme the upstreams are reached with.
+func (p *Proxy) Scheme() string {
+	return p.scheme(p.Upstreams[0])
+}
+
+func (p *Proxy) scheme(upstream string) string {
+	scheme, _, _ := strings.Cut(upstream, "://")
+	return scheme
+}
+
+// hostPorts returns the upstreams without their scheme.
+func (p *Proxy) hostPorts() []string {
+	hosts := make([]string, len(p.Upstreams))
+	for i, u := range p.Upstreams {
+		_, hosts[i], _ = strings.Cut(u, "://")
+	}
+	return hosts
+}
+
+// policy returns the directive value server uses for the proxy's policy.
+func (p *Proxy) policy(server string) string {
+	return policies[p.Policy][server]
+}
+
+// seconds rounds d up to whole seconds, the unit every server accepts.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// identifier turns a domain into a name usable for nginx variables and
+// upstream blocks. Punctuation becomes _, so a short hash of the domain keeps
+// names such as my-app.com and my.app.com apart.
+func identifier(domain string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, domain)
+	sum := sha256.Sum256([]byte(domain))
+	return name + "_" + hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,89 @@
+package webserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProxyVhostServesACMEChallengeLocally(t *testing.T) {
+	proxy, err := NewProxy("nginx", []string{"http://127.0.0.1:3000"}, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	site := Site{Domain: "app.example.com", Proxy: proxy, CertFile: "/c/fullchain.pem", KeyFile: "/c/privkey.pem"}
+	if got := site.Webroot(); got != ChallengeRoot {
+		t.Fatalf("Webroot() = %q, want %q", got, ChallengeRoot)
+	}
+
+	tests := []struct {
+		server string
+		local  []string // directives serving the challenge from ChallengeRoot
+		proxy  string   // the directive forwarding everything else
+	}{
+		{
+			server: "nginx",
+			local:  []string{"location ^~ /.well-known/acme-challenge/ {\n        root " + ChallengeRoot + ";"},
+			proxy:  "location / {",
+		},
+		{
+			server: "apache",
+			local: []string{
+				`Alias "/.well-known/acme-challenge/" "` + ChallengeRoot + `/.well-known/acme-challenge/"`,
+				`ProxyPass "/.well-known/acme-challenge/" !`,
+			},
+			proxy: `ProxyPass "/" "balancer://`,
+		},
+		{
+			server: "caddy",
+			local:  []string{"handle_path /.well-known/acme-challenge/* {\n        root * " + ChallengeRoot + "/.well-known/acme-challenge/\n        file_server"},
+			proxy:  "reverse_proxy ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.server, func(t *testing.T) {
+			ws, err := Get(tt.server)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vhost := ws.RenderVhost(site)
+			checkChallengeFirst(t, "vhost", vhost, tt.local, tt.proxy)
+			if tt.server == "caddy" {
+				// Caddy serves HTTP and HTTPS from the one site block.
+				return
+			}
+			ssl := strings.TrimPrefix(ws.AddSSL(vhost, site), vhost)
+			checkChallengeFirst(t, "ssl vhost", ssl, tt.local, tt.proxy)
+		})
+	}
+}
+
+// checkChallengeFirst fails unless every local directive appears in config
+// before the proxy directive.
+func checkChallengeFirst(t *testing.T, name, config string, local []string, proxy string) {
+	t.Helper()
+	at := strings.Index(config, proxy)
+	if at < 0 {
+		t.Fatalf("%s has no %q:\n%s", name, proxy, config)
+	}
+	for _, directive := range local {
+		i := strings.Index(config, directive)
+		if i < 0 {
+			t.Fatalf("%s does not serve the challenge locally, missing %q:\n%s", name, directive, config)
+		}
+		if i > at {
+			t.Errorf("%s has %q after the proxy rule:\n%s", name, directive, config)
+		}
+	}
+}
+
+func TestIdentifierKeepsSimilarDomainsApart(t *testing.T) {
+	a, b := identifier("my-app.com"), identifier("my.app.com")
+	if a == b {
+		t.Fatalf("my-app.com and my.app.com share the identifier %s", a)
+	}
+	for _, id := range []string{a, b} {
+		if strings.Trim(id, "abcdefghijklmnopqrstuvwxyz0123456789_") != "" {
+			t.Errorf("identifier %q has characters nginx names cannot use", id)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package webserver
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"stackroost-cli/cmd/internal/system"
+)
+
+// Site describes a virtual host managed by stackroost.
+type Site struct {
+	Domain   string
+	Aliases  []string
+	Root     string
+	CertFile string
+	KeyFile  string
+	// Proxy makes the site a reverse proxy to application servers; Root is
+	// then unused.
+	Proxy *Proxy
+}
+
+// WebServer is implemented by every supported web server driver.
+type WebServer interface {
+	// Name is the identifier used on the command line and in the config file.
+	Name() string
+	// VhostPath returns the file holding the virtual host for domain.
+	VhostPath(domain string) string
+	// RenderVhost returns the initial virtual host configuration for site.
+	RenderVhost(site Site) string
+	// SetRoot rewrites the document root in an existing configuration.
+	SetRoot(config, root string) string
+	// AddSSL returns config with TLS enabled using the site's certificate.
+	AddSSL(config string, site Site) string
+	// Enable and Disable make the virtual host for domain live or inactive.
+	Enable(domain string) error
+	Disable(domain string) error
+	// Enabled reports whether the server loads the virtual host for domain.
+	Enabled(domain string) (bool, error)
+	// ConfigTest runs the server's native syntax check.
+	ConfigTest() error
+	// Reload applies configuration changes without dropping connections.
+	Reload() error
+	// LogFile returns the path of the access or error log.
+	LogFile(logType string) string
+}
+
+var registry = map[string]WebServer{}
+
+// Register makes a driver available by name. It is called from the init
+// function of each driver file.
+func Register(ws WebServer) {
+	if _, dup := registry[ws.Name()]; dup {
+		panic("webserver: Register called twice for " + ws.Name())
+	}
+	registry[ws.Name()] = ws
+}
+
+// Get returns the driver registered under name.
+func Get(name string) (WebServer, error) {
+	ws, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown web server %q (supported: %s)", name, strings.Join(Names(), ", "))
+	}
+	return ws, nil
+}
+
+// Names lists the registered drivers in alphabetical order.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hostNames returns the primary domain followed by its aliases.
+func (s Site) hostNames() []string {
+	return append([]string{s.Domain}, s.Aliases...)
+}
+
+// replaceDirective rewrites every line containing marker with replacement,
+// keeping the original indentation.
+func replaceDirective(config, marker, replacement string) string {
+	lines := strings.Split(config, "\n")
+	for i, line := range lines {
+		if strings.Contains(line, marker) {
+			indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			lines[i] = indent + replacement
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// linked reports whether path exists, for drivers that enable a virtual host
+// by linking it into a directory the server loads.
+func linked(path string) (bool, error) {
+	_, err := system.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// run executes a command, discarding its output unless it fails.
+func run(name string, args ...string) error {
+	_, err := system.Run(name, args...)
+	return err
+}
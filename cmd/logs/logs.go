@@ -5,10 +5,11 @@ Copyright © 2025 Stackroost CLI
 package logs
 
 import (
-	"os"
-	"os/exec"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"stackroost-cli/cmd/internal/system"
+	"stackroost-cli/cmd/internal/webserver"
 )
 
 // logsCmd represents the logs command
@@ -16,7 +17,7 @@ var logsCmd = &cobra.Command{
 	Use:   "logs",
 	Short: "View server logs",
 	Long:  `View logs from web servers and system services.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		server, _ := cmd.Flags().GetString("server")
 		logType, _ := cmd.Flags().GetString("type")
 		if server == "" {
@@ -25,36 +26,26 @@ var logsCmd = &cobra.Command{
 		if logType == "" {
 			logType = "access"
 		}
-		logFile := getLogFile(server, logType)
-		execCmd := exec.Command("tail", "-f", logFile)
-		execCmd.Stdout = os.Stdout
-		execCmd.Stderr = os.Stderr
-		execCmd.Run()
+		logFile, err := getLogFile(server, logType)
+		if err != nil {
+			return err
+		}
+		_, err = system.Interactive("tail", "-f", logFile)
+		return err
 	},
 }
 
 func AddLogsCmd(root *cobra.Command) {
 	root.AddCommand(logsCmd)
 
-	logsCmd.Flags().String("server", "", "Web server (apache, nginx, caddy)")
+	logsCmd.Flags().String("server", "", "Web server ("+strings.Join(webserver.Names(), ", ")+")")
 	logsCmd.Flags().String("type", "", "Log type (access, error)")
 }
 
-func getLogFile(server, logType string) string {
-	switch server {
-	case "apache":
-		if logType == "error" {
-			return "/var/log/apache2/error.log"
-		}
-		return "/var/log/apache2/access.log"
-	case "nginx":
-		if logType == "error" {
-			return "/var/log/nginx/error.log"
-		}
-		return "/var/log/nginx/access.log"
-	case "caddy":
-		return "/var/log/caddy.log" // Caddy logs to journal or file, assuming /var/log/caddy.log
-	default:
-		return "/var/log/apache2/access.log"
+func getLogFile(server, logType string) (string, error) {
+	ws, err := webserver.Get(server)
+	if err != nil {
+		return "", err
 	}
-}
\ No newline at end of file
+	return ws.LogFile(logType), nil
+}
@@ -0,0 +1,212 @@
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"stackroost-cli/cmd/internal/logger"
+	"stackroost-cli/cmd/internal/prompt"
+)
+
+// keys caches parsed private keys by path so a passphrase is asked for at most
+// once per process, however many connections use the key.
+var keys = struct {
+	sync.Mutex
+	signers map[string]ssh.Signer
+	// loading holds the keys being read, so connections needing the same
+	// key wait for its passphrase prompt while the others go ahead.
+	loading map[string]*keyLoad
+}{signers: map[string]ssh.Signer{}, loading: map[string]*keyLoad{}}
+
+// keyLoad is a key being read; done is closed once signer or err is set.
+type keyLoad struct {
+	done   chan struct{}
+	signer ssh.Signer
+	err    error
+}
+
+// agentOnce connects to the ssh-agent behind SSH_AUTH_SOCK, if any.
+var agentOnce = sync.OnceValue(func() agent.ExtendedAgent {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil
+	}
+	return agent.NewClient(conn)
+})
+
+// authMethods returns the ways to authenticate to t: public keys (ssh-agent
+// first, then the remote's key file), then keyboard-interactive and password
+// prompts for servers that want them. If used is not nil it is told about
+// each method as it is tried, so the last one before success is the one
+// that worked.
+func authMethods(t Target, used func(method string)) []ssh.AuthMethod {
+	if used == nil {
+		used = func(string) {}
+	}
+	return []ssh.AuthMethod{
+		ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			signers, err := publicKeys(t.Key)
+			for i, s := range signers {
+				signers[i] = &reportingSigner{Signer: s, used: used, method: keyMethod(t.Key, s)}
+			}
+			return signers, err
+		}),
+		ssh.RetryableAuthMethod(ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			used("keyboard-interactive")
+			return keyboardInteractive(name, instruction, questions, echos)
+		}), 3),
+		ssh.RetryableAuthMethod(ssh.PasswordCallback(func() (string, error) {
+			used("password")
+			return prompt.Secret(fmt.Sprintf("%s@%s's password", t.User, t.Host))
+		}), 3),
+	}
+}
+
+// reportingSigner reports its key as used when asked for a signature, which
+// only happens once the server has said it would accept the key.
+type reportingSigner struct {
+	ssh.Signer
+	used   func(string)
+	method string
+}
+
+func (s *reportingSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.used(s.method)
+	return s.Signer.Sign(rand, data)
+}
+
+// keyMethod describes public key authentication with s, which is either the
+// key in keyFile or one held by the agent.
+func keyMethod(keyFile string, s ssh.Signer) string {
+	if keyFile != "" {
+		keys.Lock()
+		signer, loaded := keys.signers[keyFile]
+		keys.Unlock()
+		if loaded && bytes.Equal(signer.PublicKey().Marshal(), s.PublicKey().Marshal()) {
+			return "publickey (" + keyFile + ")"
+		}
+		if pub, err := os.ReadFile(keyFile + ".pub"); err == nil {
+			if key, _, _, _, err := ssh.ParseAuthorizedKey(pub); err == nil && bytes.Equal(key.Marshal(), s.PublicKey().Marshal()) {
+				return "publickey (" + keyFile + ", from ssh-agent)"
+			}
+		}
+	}
+	return "publickey (ssh-agent " + ssh.FingerprintSHA256(s.PublicKey()) + ")"
+}
+
+// publicKeys returns the agent's keys followed by the key in keyFile, unless
+// the agent already holds that key. A key file that cannot be loaded is
+// reported and skipped, leaving the agent's keys to try.
+func publicKeys(keyFile string) ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	if ag := agentOnce(); ag != nil {
+		if agentSigners, err := ag.Signers(); err == nil {
+			signers = agentSigners
+		}
+	}
+	if keyFile == "" {
+		return signers, nil
+	}
+	if pub, err := os.ReadFile(keyFile + ".pub"); err == nil {
+		if key, _, _, _, err := ssh.ParseAuthorizedKey(pub); err == nil {
+			for _, s := range signers {
+				if bytes.Equal(s.PublicKey().Marshal(), key.Marshal()) {
+					return signers, nil
+				}
+			}
+		}
+	}
+	signer, err := loadKey(keyFile)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Skipping key %s: %v", keyFile, err))
+		return signers, nil
+	}
+	return append(signers, signer), nil
+}
+
+// loadKey returns the parsed private key in path, reading it on first use.
+// Connections asking for a key while it is read share the result.
+func loadKey(path string) (ssh.Signer, error) {
+	keys.Lock()
+	if signer, ok := keys.signers[path]; ok {
+		keys.Unlock()
+		return signer, nil
+	}
+	if l, ok := keys.loading[path]; ok {
+		keys.Unlock()
+		<-l.done
+		return l.signer, l.err
+	}
+	l := &keyLoad{done: make(chan struct{})}
+	keys.loading[path] = l
+	keys.Unlock()
+
+	// The lock is not held here, so a passphrase prompt only holds up the
+	// connections that need this key.
+	l.signer, l.err = readKey(path)
+	keys.Lock()
+	if l.err == nil {
+		keys.signers[path] = l.signer
+	}
+	// A failed read is tried again by later connections.
+	delete(keys.loading, path)
+	keys.Unlock()
+	close(l.done)
+	return l.signer, l.err
+}
+
+// readKey parses a private key file, asking for its passphrase if it is
+// encrypted.
+func readKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read private key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		var passphrase string
+		passphrase, err = prompt.Secret("Enter passphrase for key " + path)
+		if err != nil {
+			return nil, fmt.Errorf("private key %s is encrypted: %w", path, err)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// keyboardInteractive answers server prompts on the terminal.
+func keyboardInteractive(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	if text := strings.TrimSpace(name + "\n" + instruction); text != "" {
+		fmt.Fprintln(os.Stderr, text)
+	}
+	answers := make([]string, len(questions))
+	for i, question := range questions {
+		label := strings.TrimSuffix(strings.TrimSpace(question), ":")
+		var err error
+		if echos[i] {
+			answers[i], err = prompt.Line(label)
+		} else {
+			answers[i], err = prompt.Secret(label)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return answers, nil
+}
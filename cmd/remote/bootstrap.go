@@ -0,0 +1,168 @@
+package remote
+
+import (
+	"debug/elf"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"stackroost-cli/cmd/internal/config"
+	"stackroost-cli/cmd/internal/logger"
+	"stackroost-cli/cmd/internal/system"
+)
+
+// installPath is where bootstrap puts stackroost on a remote, so that it is
+// in the PATH of login and non-login shells alike.
+const installPath = "/usr/local/bin/stackroost"
+
+// unameArch maps uname -m to GOARCH.
+var unameArch = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"armv7l":  "arm",
+	"armv6l":  "arm",
+	"i686":    "386",
+	"i386":    "386",
+}
+
+// elfArch maps an ELF machine to GOARCH.
+var elfArch = map[elf.Machine]string{
+	elf.EM_X86_64:  "amd64",
+	elf.EM_AARCH64: "arm64",
+	elf.EM_ARM:     "arm",
+	elf.EM_386:     "386",
+}
+
+// Bootstrap installs stackroost on t and checks that the host can be managed
+// with it. binary is the build to upload; by default it is the running
+// executable, which must then match the remote's architecture.
+func Bootstrap(t Target, binary string) error {
+	if binary == "" {
+		self, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		binary = self
+	}
+	tr, err := newTransfer(t, TransferOptions{})
+	if err != nil {
+		return err
+	}
+	defer tr.Close()
+
+	out, err := tr.output("uname -sm; id -u")
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 3 {
+		return fmt.Errorf("unexpected output from uname on %s: %q", t.Name, out)
+	}
+	if fields[0] != "Linux" {
+		return fmt.Errorf("%s runs %s; stackroost only supports Linux", t.Name, fields[0])
+	}
+	arch, ok := unameArch[fields[1]]
+	if !ok {
+		return fmt.Errorf("%s has an unsupported architecture: %s", t.Name, fields[1])
+	}
+	if err := checkBinary(binary, arch); err != nil {
+		return err
+	}
+	if fields[2] != "0" {
+		tr.opts.Sudo = true
+	}
+
+	sum, err := localChecksum(binary)
+	if err != nil {
+		return err
+	}
+	if tr.checksum(installPath) == sum {
+		logger.Info(fmt.Sprintf("%s is already up to date on %s", installPath, t.Name))
+	} else if err := tr.install(binary, sum); err != nil {
+		return err
+	}
+	return compatibility(tr)
+}
+
+// checkBinary makes sure binary is a Linux executable for arch.
+func checkBinary(binary, arch string) error {
+	f, err := elf.Open(binary)
+	if err != nil {
+		return fmt.Errorf("%s is not a Linux binary; build one with GOOS=linux GOARCH=%s and pass it with --binary", binary, arch)
+	}
+	defer f.Close()
+	if got := elfArch[f.Machine]; got != arch {
+		return fmt.Errorf("%s is built for %s but the remote is %s; build one with GOOS=linux GOARCH=%s and pass it with --binary", binary, f.Machine, arch, arch)
+	}
+	return nil
+}
+
+// install uploads binary to a staging directory, checks that it arrived
+// intact and moves it to installPath.
+func (tr *transfer) install(binary, sum string) error {
+	info, err := os.Stat(binary)
+	if err != nil {
+		return err
+	}
+	if system.Simulated("upload", fmt.Sprintf("%s -> %s", binary, tr.remote(installPath))) {
+		return nil
+	}
+	stage, err := tr.tempDir()
+	if err != nil {
+		return err
+	}
+	defer tr.run(fmt.Sprintf("rm -rf %s", shellQuote(stage)))
+	staged := path.Join(stage, "stackroost")
+	if err := tr.upload(binary, staged, info); err != nil {
+		return err
+	}
+	if got := tr.checksum(staged); got != sum {
+		return fmt.Errorf("checksum mismatch after uploading to %s: expected %s, got %q", tr.target.Name, sum, got)
+	}
+	if err := tr.run(fmt.Sprintf("%sinstall -m 0755 %s %s", tr.sudo(), shellQuote(staged), installPath)); err != nil {
+		return err
+	}
+	logger.Success(fmt.Sprintf("Installed stackroost to %s", tr.remote(installPath)))
+	return nil
+}
+
+// compatibility inspects the remote behind tr, caches what it found and
+// reports whether stackroost can manage it.
+func compatibility(tr *transfer) error {
+	f, err := gatherFacts(tr.ssh, tr.target.Name)
+	if err != nil {
+		return err
+	}
+	if err := saveFacts(tr.target.Name, f); err != nil {
+		return err
+	}
+	if err := config.Save(); err != nil {
+		return err
+	}
+	var problems []string
+	support := "supported"
+	if !f.Supported() {
+		support = "not supported"
+		problems = append(problems, fmt.Sprintf("distribution %s is not supported", f.Distro))
+	}
+	systemd := "yes"
+	if !f.Systemd {
+		systemd = "no"
+		problems = append(problems, "systemd is not running")
+	}
+	var webServers []string
+	for _, ws := range f.WebServers {
+		webServers = append(webServers, ws.Service)
+	}
+	fmt.Printf("Distribution: %s (%s)\n", f.Distro, support)
+	fmt.Printf("systemd:      %s\n", systemd)
+	fmt.Printf("Web servers:  %s\n", orNone(strings.Join(webServers, ", ")))
+	if len(problems) > 0 {
+		return fmt.Errorf("%s cannot be fully managed by stackroost: %s", tr.target.Name, strings.Join(problems, "; "))
+	}
+	logger.Success(fmt.Sprintf("%s is ready for stackroost", tr.target.Name))
+	return nil
+}
@@ -0,0 +1,352 @@
+package remote
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+)
+
+// A control master is a background stackroost process that holds the
+// connection to one host and lends it to later invocations through a unix
+// socket, like OpenSSH's ControlMaster and ControlPersist. Invocations speak
+// SSH to the master over the socket; it relays every channel they open, and
+// exits once no invocation has used it for the configured idle period.
+
+// controlExit is the global request that asks a master to exit.
+const controlExit = "exit@stackroost"
+
+// controlPersist is how long a control master keeps an idle connection open,
+// set with ssh.control_persist in the config. Zero disables control masters.
+func controlPersist() time.Duration {
+	if !controlSupported {
+		return 0
+	}
+	configMu.Lock()
+	defer configMu.Unlock()
+	return viper.GetDuration("ssh.control_persist")
+}
+
+// controlPath returns the socket of the control master for t, in a directory
+// only the current user can enter.
+func controlPath(t Target) (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cache, "stackroost", "control")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(poolKey(t)))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".sock"), nil
+}
+
+// startMu makes masters start one at a time, so their host key and
+// passphrase prompts do not interleave on the terminal.
+var startMu sync.Mutex
+
+// controlClient returns a client for t served by its control master,
+// starting the master if none is running.
+func controlClient(t Target) (*ssh.Client, error) {
+	path, err := controlPath(t)
+	if err != nil {
+		return nil, err
+	}
+	if client, err := dialControl(path, t); err == nil {
+		return client, nil
+	}
+	startMu.Lock()
+	defer startMu.Unlock()
+	// Whatever is left at path belongs to a master that is gone.
+	os.Remove(path)
+	if err := startMaster(t, path); err != nil {
+		return nil, err
+	}
+	return dialControl(path, t)
+}
+
+func dialControl(path string, t Target) (*ssh.Client, error) {
+	pub, err := os.ReadFile(path + ".pub")
+	if err != nil {
+		return nil, err
+	}
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("unix", path, connectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, path, &ssh.ClientConfig{
+		User:            t.User,
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// startMaster runs a control master for t in the background and waits until
+// it has connected. Until then it shares the terminal, for prompts.
+func startMaster(t Target, path string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	args := []string{"remote", "control-master", t.Name, "--socket", path, "--connect-timeout", connectTimeout.String()}
+	if file := viper.ConfigFileUsed(); file != "" {
+		args = append(args, "--config", file)
+	}
+	cmd := exec.Command(self, args...)
+	// The master sees these as descriptors 3, 4 and 5; see ServeControl.
+	cmd.ExtraFiles = []*os.File{w, os.Stdin, os.Stderr}
+	detach(cmd)
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return fmt.Errorf("failed to start control master: %w", err)
+	}
+	status, _ := io.ReadAll(r)
+	if string(status) == "ok" {
+		return cmd.Process.Release()
+	}
+	cmd.Wait()
+	if len(status) == 0 {
+		return fmt.Errorf("control master for %s exited", t.Name)
+	}
+	return errors.New(string(status))
+}
+
+// ServeControl runs the control master for a remote, which must have been
+// started by startMaster.
+func ServeControl(name, path string) error {
+	status := os.NewFile(3, "status")
+	os.Stdin, os.Stderr = os.NewFile(4, "stdin"), os.NewFile(5, "stderr")
+	m, err := newMaster(name, path)
+	if err != nil {
+		fmt.Fprint(status, err.Error())
+		return err
+	}
+	fmt.Fprint(status, "ok")
+	status.Close()
+	// Let go of the terminal, or whatever pipe the first invocation was
+	// writing to, so it is not held open for as long as the master runs.
+	os.Stdin.Close()
+	os.Stderr.Close()
+	if null, err := os.OpenFile(os.DevNull, os.O_RDWR, 0); err == nil {
+		os.Stdin, os.Stderr = null, null
+	}
+	return m.serve()
+}
+
+// master lends client to the invocations connecting to listener.
+type master struct {
+	client   *ssh.Client
+	listener net.Listener
+	config   *ssh.ServerConfig
+	persist  time.Duration
+	pub      string
+
+	mu     sync.Mutex
+	active int
+	idle   *time.Timer
+	wg     sync.WaitGroup
+}
+
+func newMaster(name, path string) (*master, error) {
+	t, err := Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dial(t, 0)
+	if err != nil {
+		return nil, err
+	}
+	m := &master{client: client, persist: controlPersist(), pub: path + ".pub"}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err == nil {
+		var signer ssh.Signer
+		if signer, err = ssh.NewSignerFromKey(key); err == nil {
+			// The socket directory is private, so anyone who can connect
+			// is the user who started the master.
+			m.config = &ssh.ServerConfig{NoClientAuth: true}
+			m.config.AddHostKey(signer)
+			err = os.WriteFile(m.pub, ssh.MarshalAuthorizedKey(signer.PublicKey()), 0600)
+		}
+	}
+	if err == nil {
+		m.listener, err = net.Listen("unix", path)
+	}
+	if err != nil {
+		client.Close()
+		os.Remove(m.pub)
+		return nil, err
+	}
+	return m, nil
+}
+
+// serve relays connections until the master has been idle for persist, is
+// asked to exit, or the host closes the connection.
+func (m *master) serve() error {
+	defer os.Remove(m.pub)
+	defer m.client.Close()
+	m.idle = time.AfterFunc(m.persist, m.expire)
+	go func() {
+		m.client.Wait()
+		m.listener.Close()
+	}()
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			break
+		}
+		m.mu.Lock()
+		m.active++
+		m.idle.Stop()
+		m.mu.Unlock()
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.handle(conn)
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if m.active--; m.active == 0 {
+				m.idle.Reset(m.persist)
+			}
+		}()
+	}
+	m.wg.Wait()
+	return nil
+}
+
+func (m *master) expire() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.active == 0 {
+		m.listener.Close()
+	}
+}
+
+// handle serves one invocation.
+func (m *master) handle(conn net.Conn) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, m.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sconn.Close()
+	go func() {
+		for req := range reqs {
+			if req.Type == controlExit {
+				req.Reply(true, nil)
+				m.listener.Close()
+				m.client.Close()
+				continue
+			}
+			// Remote port forwarding would need forwarded connections
+			// routed back to the right invocation; it is not relayed.
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}()
+	for ch := range chans {
+		go relayChannel(m.client, ch)
+	}
+}
+
+// relayChannel opens the channel an invocation asked for on the host and
+// passes data and requests between the two until both sides are done.
+func relayChannel(client *ssh.Client, newCh ssh.NewChannel) {
+	up, upReqs, err := client.OpenChannel(newCh.ChannelType(), newCh.ExtraData())
+	if err != nil {
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) {
+			newCh.Reject(openErr.Reason, openErr.Message)
+		} else {
+			newCh.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+	down, downReqs, err := newCh.Accept()
+	if err != nil {
+		up.Close()
+		return
+	}
+	go func() {
+		relayRequests(up, downReqs)
+		// The invocation closed the channel.
+		up.Close()
+	}()
+	go func() {
+		io.Copy(up, down)
+		up.CloseWrite()
+	}()
+	// Exit statuses arrive as requests before the host closes the channel,
+	// so the channel is closed once they have all been passed on.
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		io.Copy(down, up)
+		down.CloseWrite()
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(down.Stderr(), up.Stderr())
+	}()
+	go func() {
+		defer wg.Done()
+		relayRequests(down, upReqs)
+	}()
+	wg.Wait()
+	down.Close()
+}
+
+func relayRequests(to ssh.Channel, reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		ok, err := to.SendRequest(req.Type, req.WantReply, req.Payload)
+		if req.WantReply {
+			req.Reply(ok && err == nil, nil)
+		}
+	}
+}
+
+// StopControl asks the control master for t, if one is running, to close its
+// connection and exit. It reports whether there was one.
+func StopControl(t Target) (bool, error) {
+	path, err := controlPath(t)
+	if err != nil {
+		return false, err
+	}
+	client, err := dialControl(path, t)
+	if err != nil {
+		return false, nil
+	}
+	defer client.Close()
+	if _, _, err := client.SendRequest(controlExit, true, nil); err != nil && !errors.Is(err, io.EOF) {
+		return true, err
+	}
+	return true, nil
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package remote
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+const controlSupported = true
+
+// detach starts cmd in a session of its own, so it outlives the invocation
+// and is not sent the terminal's signals.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,9 @@
+package remote
+
+import "os/exec"
+
+// controlSupported is false: a master is handed its parent's descriptors,
+// which Windows cannot pass on.
+const controlSupported = false
+
+func detach(cmd *exec.Cmd) {}
@@ -0,0 +1,278 @@
+package remote
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+)
+
+// Target is a remote resolved to everything needed to connect to it.
+type Target struct {
+	Name string
+	User string
+	Host string
+	Port int
+	Key  string
+	// Jump lists the hosts to tunnel through, in order, like ProxyJump.
+	// Each is a remote name, an ssh config Host or [user@]host[:port].
+	Jump []string
+}
+
+// Addr returns the host:port to dial.
+func (t Target) Addr() string {
+	return net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
+// configMu serialises access to the remotes config between connections made
+// in parallel, since host key checks may record fingerprints.
+var configMu sync.Mutex
+
+// Lookup resolves a configured remote, falling back to a Host entry in
+// ~/.ssh/config so hosts known to ssh can be used without remote add.
+func Lookup(name string) (Target, error) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	userhost := viper.GetString("remotes." + name + ".userhost")
+	if userhost == "" {
+		sshConfig, err := loadSSHConfig(sshConfigFile())
+		if err != nil {
+			return Target{}, err
+		}
+		if !sshConfig.defines(name) {
+			return Target{}, fmt.Errorf("unknown remote: %s", name)
+		}
+		return sshConfig.target(name)
+	}
+	t, err := parseUserHost(userhost)
+	if err != nil {
+		return Target{}, err
+	}
+	t.Name = name
+	t.Key = viper.GetString("remotes." + name + ".key")
+	t.Jump = viper.GetStringSlice("remotes." + name + ".jump")
+	return t, nil
+}
+
+// parseUserHost parses user@host, user@host:port or user@[ipv6]:port.
+func parseUserHost(userhost string) (Target, error) {
+	at := strings.LastIndex(userhost, "@")
+	if at <= 0 || at == len(userhost)-1 {
+		return Target{}, fmt.Errorf("invalid user@host format: %s", userhost)
+	}
+	t := Target{User: userhost[:at], Host: userhost[at+1:], Port: 22}
+	if host, port, err := net.SplitHostPort(t.Host); err == nil {
+		n, err := strconv.Atoi(port)
+		if err != nil || n <= 0 || n > 65535 {
+			return Target{}, fmt.Errorf("invalid port in %s", userhost)
+		}
+		t.Host, t.Port = host, n
+	}
+	t.Host = strings.Trim(t.Host, "[]")
+	return t, nil
+}
+
+// formatUserHost is the inverse of parseUserHost, leaving out the default port.
+func formatUserHost(user, host string, port int) string {
+	if port == 0 || port == 22 {
+		if strings.Contains(host, ":") {
+			return user + "@[" + host + "]"
+		}
+		return user + "@" + host
+	}
+	return user + "@" + net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// clientConfig returns the SSH client configuration for t.
+func clientConfig(t Target) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            t.User,
+		Auth:            authMethods(t, nil),
+		HostKeyCallback: hostKeyCallback(t.Name),
+	}
+}
+
+// connectTimeout bounds connecting to a host, up to the point where it has
+// presented its host key. It is set with --connect-timeout.
+var connectTimeout = 30 * time.Second
+
+// maxJumpDepth bounds jump hosts that themselves need jump hosts. Loops
+// between remotes that jump through each other are caught by checkJumps.
+const maxJumpDepth = 8
+
+// checkJumps follows the first jump host of t, and of that host in turn,
+// which are the connections taken from the pool, and fails on a loop before
+// anything is dialled. A loop would otherwise wait forever on a pooled
+// connection that is waiting for itself.
+func checkJumps(t Target) error {
+	chain := []string{t.Name}
+	seen := map[string]bool{poolKey(t): true}
+	for len(t.Jump) > 0 && len(chain) <= maxJumpDepth+1 {
+		hop, err := resolveHop(t.Jump[0])
+		if err != nil {
+			return err
+		}
+		chain = append(chain, hop.Name)
+		if seen[poolKey(hop)] {
+			return fmt.Errorf("jump host loop: %s", strings.Join(chain, " -> "))
+		}
+		seen[poolKey(hop)] = true
+		t = hop
+	}
+	return nil
+}
+
+// Dial connects and authenticates to t, tunnelling through its jump hosts.
+// Closing the returned client also closes the connections to the jump hosts
+// it owns; the first jump host is shared through the pool.
+func Dial(t Target) (*ssh.Client, error) {
+	return dial(t, 0)
+}
+
+func dial(t Target, depth int) (*ssh.Client, error) {
+	conn, err := dialConn(t, depth)
+	if err != nil {
+		return nil, err
+	}
+	return handshake(conn, t)
+}
+
+// handshake sets up an SSH client for t over conn, closing conn on failure.
+func handshake(conn net.Conn, t Target) (*ssh.Client, error) {
+	return handshakeWith(conn, t, clientConfig(t))
+}
+
+func handshakeWith(conn net.Conn, t Target, cfg *ssh.ClientConfig) (*ssh.Client, error) {
+	if connectTimeout > 0 {
+		// Tunnelled connections do not support deadlines; the jump host's
+		// own handshake was bounded instead.
+		conn.SetDeadline(time.Now().Add(connectTimeout))
+		check := cfg.HostKeyCallback
+		cfg.HostKeyCallback = func(hostname string, addr net.Addr, key ssh.PublicKey) error {
+			// The server has answered. Prompts from here on, for the host
+			// key or credentials, take as long as the user needs.
+			conn.SetDeadline(time.Time{})
+			return check(hostname, addr, key)
+		}
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, t.Addr(), cfg)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to %s (%s): %w", t.Name, t.Addr(), err)
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// dialConn opens a TCP connection to t, directly or through its jump hosts.
+func dialConn(t Target, depth int) (net.Conn, error) {
+	if depth == 0 {
+		if err := checkJumps(t); err != nil {
+			return nil, err
+		}
+	}
+	if depth > maxJumpDepth {
+		return nil, fmt.Errorf("too many nested jump hosts reaching %s", t.Name)
+	}
+	if len(t.Jump) == 0 {
+		conn, err := net.DialTimeout("tcp", t.Addr(), connectTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s (%s): %w", t.Name, t.Addr(), err)
+		}
+		return conn, nil
+	}
+	// via is the last hop reached. The first is shared with every other
+	// connection through it; later ones belong to this connection.
+	var via *ssh.Client
+	shared := false
+	for i, spec := range t.Jump {
+		hop, err := resolveHop(spec)
+		if err != nil {
+			if via != nil && !shared {
+				via.Close()
+			}
+			return nil, err
+		}
+		if i == 0 {
+			// Only the first hop's own jump hosts matter, as with ProxyJump.
+			via, err = connect(hop, depth+1)
+		} else {
+			via, err = through(via, shared, hop)
+		}
+		if err != nil {
+			return nil, err
+		}
+		shared = i == 0
+	}
+	conn, err := via.Dial("tcp", t.Addr())
+	if err != nil {
+		if !shared {
+			via.Close()
+		}
+		return nil, fmt.Errorf("failed to reach %s (%s) through %s: %w", t.Name, t.Addr(), t.Jump[len(t.Jump)-1], err)
+	}
+	return &tunnelConn{Conn: conn, via: via, shared: shared}, nil
+}
+
+// through connects to t over via. Unless via is shared, it is closed along
+// with the new client, or straight away if the connection fails.
+func through(via *ssh.Client, shared bool, t Target) (*ssh.Client, error) {
+	conn, err := via.Dial("tcp", t.Addr())
+	if err != nil {
+		if !shared {
+			via.Close()
+		}
+		return nil, fmt.Errorf("failed to reach jump host %s (%s): %w", t.Name, t.Addr(), err)
+	}
+	return handshake(&tunnelConn{Conn: conn, via: via, shared: shared}, t)
+}
+
+// tunnelConn is a connection forwarded by a jump host; closing it also
+// closes the jump host's client unless that is shared.
+type tunnelConn struct {
+	net.Conn
+	via    *ssh.Client
+	shared bool
+}
+
+func (c *tunnelConn) Close() error {
+	err := c.Conn.Close()
+	if !c.shared {
+		c.via.Close()
+	}
+	return err
+}
+
+// resolveHop resolves a jump host spec. Remote names and ssh config Hosts use
+// their own settings; anything else is [user@]host[:port] with the defaults
+// ssh would apply to that host.
+func resolveHop(spec string) (Target, error) {
+	if t, err := Lookup(spec); err == nil {
+		return t, nil
+	}
+	user, hostport, hasUser := strings.Cut(spec, "@")
+	if !hasUser {
+		user, hostport = "", spec
+	}
+	parsed, err := parseUserHost("_@" + hostport)
+	if err != nil {
+		return Target{}, fmt.Errorf("invalid jump host %q", spec)
+	}
+	sshConfig, err := loadSSHConfig(sshConfigFile())
+	if err != nil {
+		return Target{}, err
+	}
+	t, err := sshConfig.target(parsed.Host)
+	if err != nil {
+		return Target{}, err
+	}
+	t.Name, t.Port = spec, parsed.Port
+	if user != "" {
+		t.User = user
+	}
+	return t, nil
+}
@@ -0,0 +1,206 @@
+package remote
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+)
+
+// testServer is an in-process SSH server that accepts any client. It answers
+// "name" requests with its name and forwards direct-tcpip channels to the
+// servers in routes, over net.Pipe.
+type testServer struct {
+	name   string
+	key    ssh.Signer
+	routes map[string]*testServer
+}
+
+func newTestServer(t *testing.T, name string) *testServer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testServer{name: name, key: key, routes: map[string]*testServer{}}
+}
+
+func (s *testServer) fingerprint() string {
+	return ssh.FingerprintSHA256(s.key.PublicKey())
+}
+
+// serve runs the SSH protocol on conn until the client goes away.
+func (s *testServer) serve(conn net.Conn) {
+	conn = newQueuedConn(conn)
+	cfg := &ssh.ServerConfig{NoClientAuth: true}
+	cfg.AddHostKey(s.key)
+	sc, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sc.Close()
+	go func() {
+		for req := range reqs {
+			req.Reply(req.Type == "name", []byte(s.name))
+		}
+	}()
+	for ch := range chans {
+		var dest struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if ch.ChannelType() != "direct-tcpip" || ssh.Unmarshal(ch.ExtraData(), &dest) != nil {
+			ch.Reject(ssh.UnknownChannelType, "not supported")
+			continue
+		}
+		next, ok := s.routes[net.JoinHostPort(dest.Host, strconv.Itoa(int(dest.Port)))]
+		if !ok {
+			ch.Reject(ssh.ConnectionFailed, "no route")
+			continue
+		}
+		channel, chReqs, err := ch.Accept()
+		if err != nil {
+			continue
+		}
+		go ssh.DiscardRequests(chReqs)
+		near, far := net.Pipe()
+		go next.serve(far)
+		go func() {
+			io.Copy(near, channel)
+			near.Close()
+		}()
+		go func() {
+			io.Copy(channel, near)
+			channel.Close()
+		}()
+	}
+}
+
+// queuedConn hands its writes to a goroutine. Both ends of an SSH connection
+// send their version and key exchange before reading, which deadlocks on a
+// bare net.Pipe.
+type queuedConn struct {
+	net.Conn
+	writes chan []byte
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newQueuedConn(conn net.Conn) *queuedConn {
+	c := &queuedConn{Conn: conn, writes: make(chan []byte, 64), done: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case b := <-c.writes:
+				if _, err := c.Conn.Write(b); err != nil {
+					c.Close()
+					return
+				}
+			case <-c.done:
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func (c *queuedConn) Write(b []byte) (int, error) {
+	select {
+	case c.writes <- bytes.Clone(b):
+		return len(b), nil
+	case <-c.done:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *queuedConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
+
+// listen serves s on a loopback port for the rest of the test and returns
+// the port.
+func (s *testServer) listen(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// askName returns the name of the server client is connected to.
+func askName(t *testing.T, client *ssh.Client) string {
+	t.Helper()
+	ok, name, err := client.SendRequest("name", true, nil)
+	if err != nil || !ok {
+		t.Fatalf("name request failed: ok=%v err=%v", ok, err)
+	}
+	return string(name)
+}
+
+// useTestConfig points HOME and the config at a temporary directory, so host
+// keys and fingerprints are recorded there.
+func useTestConfig(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	viper.Reset()
+	viper.SetConfigFile(filepath.Join(home, ".stackroost.yaml"))
+	t.Cleanup(viper.Reset)
+	t.Cleanup(CloseConnections)
+	return home
+}
+
+func TestDialThroughJumpHost(t *testing.T) {
+	useTestConfig(t)
+	app := newTestServer(t, "app")
+	bastion := newTestServer(t, "bastion")
+	// Only the bastion can reach app.internal.
+	bastion.routes["app.internal:22"] = app
+	port := bastion.listen(t)
+
+	viper.Set("remotes.bastion.userhost", "ops@127.0.0.1:"+strconv.Itoa(port))
+	viper.Set("remotes.bastion.fingerprint", bastion.fingerprint())
+	viper.Set("remotes.app.userhost", "deploy@app.internal")
+	viper.Set("remotes.app.fingerprint", app.fingerprint())
+	viper.Set("remotes.app.jump", []string{"bastion"})
+
+	target, err := Lookup("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial(target)
+	if err != nil {
+		t.Fatalf("Dial through jump host: %v", err)
+	}
+	defer client.Close()
+	if got := askName(t, client); got != "app" {
+		t.Errorf("connected to %q, want app", got)
+	}
+}
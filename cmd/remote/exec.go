@@ -0,0 +1,209 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ExecOptions controls how a command runs on a remote.
+type ExecOptions struct {
+	// Timeout stops the command after this long; zero means no limit.
+	Timeout time.Duration
+	// TTY attaches the command to a pseudo-terminal driven by the local
+	// terminal, for commands such as sudo that insist on one.
+	TTY bool
+}
+
+// timeoutStatus is the exit status for commands stopped by --timeout, as
+// with timeout(1).
+const timeoutStatus = 124
+
+// interruptedStatus is the exit status for a run interrupted by Ctrl-C or
+// SIGTERM, as a shell reports it.
+const interruptedStatus = 130
+
+// killGrace is how long a remote command has to exit after being sent
+// SIGTERM before the session is closed on it.
+const killGrace = 5 * time.Second
+
+// ExitError reports a remote command that did not succeed. stackroost exits
+// with the command's own status.
+type ExitError struct {
+	Remote string
+	Status int
+	// Reason replaces the exit status in the message, e.g. "was killed by
+	// signal TERM".
+	Reason string
+}
+
+func (e *ExitError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("command on %s %s", e.Remote, e.Reason)
+	}
+	return fmt.Sprintf("command on %s exited with status %d", e.Remote, e.Status)
+}
+
+// ExitCode returns the remote command's exit status.
+func (e *ExitError) ExitCode() int { return e.Status }
+
+func executeRemoteCommand(t Target, command string, opts ExecOptions) error {
+	_, err := run(t, command, os.Stdout, os.Stderr, opts)
+	return err
+}
+
+// Shell opens an interactive login shell on t in the local terminal.
+func Shell(t Target) error {
+	_, err := run(t, "", os.Stdout, os.Stderr, ExecOptions{TTY: true})
+	return err
+}
+
+// run executes command on t, or a login shell if command is empty, and
+// returns its exit status. Connection failures are reported with status -1.
+func run(t Target, command string, stdout, stderr io.Writer, opts ExecOptions) (status int, err error) {
+	client, err := Connect(t)
+	if err != nil {
+		return -1, err
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return -1, fmt.Errorf("failed to create session: %w", err)
+	}
+	defer session.Close()
+
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if opts.TTY {
+		// Ctrl-C reaches the remote through the terminal itself.
+		restore, err := attachTerminal(session)
+		if err != nil {
+			return -1, err
+		}
+		defer restore()
+	} else {
+		unregister := relay.add(session)
+		defer func() {
+			if unregister() && err == nil {
+				status, err = interruptedStatus, &ExitError{Remote: t.Name, Status: interruptedStatus, Reason: "was interrupted"}
+			}
+		}()
+	}
+	var timedOut bool
+	var mu sync.Mutex
+	if opts.Timeout > 0 {
+		timer := time.AfterFunc(opts.Timeout, func() {
+			mu.Lock()
+			timedOut = true
+			mu.Unlock()
+			stop(session)
+		})
+		defer timer.Stop()
+	}
+
+	if command == "" {
+		if err = session.Shell(); err == nil {
+			err = session.Wait()
+		}
+	} else {
+		err = session.Run(command)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	var exitErr *ssh.ExitError
+	switch {
+	case timedOut:
+		return timeoutStatus, &ExitError{Remote: t.Name, Status: timeoutStatus, Reason: fmt.Sprintf("timed out after %s", opts.Timeout)}
+	case err == nil:
+		return 0, nil
+	case errors.As(err, &exitErr):
+		e := &ExitError{Remote: t.Name, Status: exitErr.ExitStatus()}
+		if exitErr.Signal() != "" {
+			e.Reason = "was killed by signal " + exitErr.Signal()
+		}
+		return e.Status, e
+	}
+	return -1, fmt.Errorf("command on %s failed: %w", t.Name, err)
+}
+
+// stop asks a remote command to terminate and closes the session if it is
+// still running after killGrace. Not every server honours signals.
+func stop(session *ssh.Session) {
+	session.Signal(ssh.SIGTERM)
+	time.AfterFunc(killGrace, func() { session.Close() })
+}
+
+// relay forwards Ctrl-C and SIGTERM received by stackroost to the remote
+// commands it is running, so they are interrupted rather than left running
+// when the local side goes away. A second Ctrl-C closes the sessions.
+// Signals are only caught while a session is registered.
+var relay = &signalRelay{sessions: map[*ssh.Session]bool{}}
+
+type signalRelay struct {
+	mu       sync.Mutex
+	sessions map[*ssh.Session]bool
+	signals  chan os.Signal
+	// missed gets whether the current handler caught a signal with no
+	// session to forward it to, once the handler has stopped.
+	missed chan bool
+}
+
+// add registers a running session, catching signals from the first one on,
+// and returns a function that unregisters it. The last session to go stops
+// the handler, and its function reports whether a signal went unforwarded,
+// so the caller can fail as if it had not been caught.
+func (r *signalRelay) add(s *ssh.Session) func() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.sessions) == 0 {
+		r.signals, r.missed = make(chan os.Signal, 1), make(chan bool, 1)
+		signal.Notify(r.signals, os.Interrupt, syscall.SIGTERM)
+		go r.forward(r.signals, r.missed)
+	}
+	r.sessions[s] = true
+	return func() bool {
+		r.mu.Lock()
+		delete(r.sessions, s)
+		if len(r.sessions) > 0 {
+			r.mu.Unlock()
+			return false
+		}
+		signal.Stop(r.signals)
+		close(r.signals)
+		missed := r.missed
+		r.mu.Unlock()
+		return <-missed
+	}
+}
+
+func (r *signalRelay) forward(signals <-chan os.Signal, missed chan<- bool) {
+	received, unforwarded := 0, false
+	for sig := range signals {
+		received++
+		r.mu.Lock()
+		if len(r.sessions) == 0 {
+			// The last session ended as the signal came in.
+			unforwarded = true
+		}
+		remote := ssh.SIGINT
+		if sig == syscall.SIGTERM {
+			remote = ssh.SIGTERM
+		}
+		for s := range r.sessions {
+			if received > 1 {
+				s.Close()
+			} else {
+				s.Signal(remote)
+			}
+		}
+		r.mu.Unlock()
+	}
+	missed <- unforwarded
+}
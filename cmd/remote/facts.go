@@ -0,0 +1,342 @@
+package remote
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.yaml.in/yaml/v3"
+	"golang.org/x/crypto/ssh"
+	"stackroost-cli/cmd/internal/config"
+	"stackroost-cli/cmd/internal/distro"
+	"stackroost-cli/cmd/internal/logger"
+)
+
+// Facts describes a remote host as found when it was last inspected.
+type Facts struct {
+	Collected  time.Time `json:"collected"`
+	Hostname   string    `json:"hostname"`
+	Distro     string    `json:"distro"`
+	DistroLike []string  `json:"distro_like,omitempty"`
+	OS         string    `json:"os,omitempty"`
+	Kernel     string    `json:"kernel"`
+	Arch       string    `json:"arch"`
+	CPUs       int       `json:"cpus"`
+	// Memory and disk figures are in bytes; disk is the root filesystem.
+	Memory          int64       `json:"memory"`
+	MemoryAvailable int64       `json:"memory_available"`
+	Disk            int64       `json:"disk"`
+	DiskFree        int64       `json:"disk_free"`
+	Systemd         bool        `json:"systemd"`
+	WebServers      []WebServer `json:"web_servers,omitempty"`
+	// Ports are the TCP ports something listens on.
+	Ports []int `json:"ports,omitempty"`
+	// Certbot and Stackroost hold the installed versions, empty when missing.
+	Certbot    string `json:"certbot,omitempty"`
+	Stackroost string `json:"stackroost,omitempty"`
+}
+
+// WebServer is a web server installed on a remote.
+type WebServer struct {
+	Name    string `json:"name"`
+	Service string `json:"service"`
+	Active  bool   `json:"active"`
+}
+
+// Supported reports whether stackroost can manage the remote's web servers.
+func (f *Facts) Supported() bool {
+	rel := distro.OSRelease{ID: f.Distro, IDLike: f.DistroLike}
+	return distro.Supported(rel.Distro())
+}
+
+// factsScript prints each fact under an @@ header for parseFacts. Only
+// commands found on every Linux system are required.
+const factsScript = `export PATH="$PATH:/usr/local/sbin:/usr/sbin:/sbin"
+echo @@os-release; cat /etc/os-release 2>/dev/null
+echo @@hostname; hostname 2>/dev/null || cat /proc/sys/kernel/hostname
+echo @@uname; uname -rm
+echo @@cpus; nproc 2>/dev/null || getconf _NPROCESSORS_ONLN
+echo @@meminfo; cat /proc/meminfo
+echo @@disk; df -Pk / | tail -n 1
+echo @@systemd; [ -d /run/systemd/system ] && echo yes
+echo @@servers
+for s in apache2 httpd nginx caddy; do
+	if command -v $s >/dev/null 2>&1; then
+		systemctl is-active --quiet $s 2>/dev/null && echo "$s active" || echo "$s inactive"
+	fi
+done
+echo @@tcp; cat /proc/net/tcp /proc/net/tcp6 2>/dev/null
+echo @@certbot; command -v certbot >/dev/null 2>&1 && certbot --version 2>&1
+echo @@stackroost; command -v stackroost >/dev/null 2>&1 && { stackroost --version 2>/dev/null || echo unknown; }
+true`
+
+// webServerNames maps service names to the names stackroost uses.
+var webServerNames = map[string]string{
+	"apache2": "apache",
+	"httpd":   "apache",
+	"nginx":   "nginx",
+	"caddy":   "caddy",
+}
+
+// GatherFacts connects to t and inspects it.
+func GatherFacts(t Target) (*Facts, error) {
+	client, err := Connect(t)
+	if err != nil {
+		return nil, err
+	}
+	return gatherFacts(client, t.Name)
+}
+
+func gatherFacts(client *ssh.Client, name string) (*Facts, error) {
+	out, err := output(client, name, factsScript)
+	if err != nil {
+		return nil, err
+	}
+	return parseFacts(out), nil
+}
+
+// parseFacts reads the output of factsScript.
+func parseFacts(out string) *Facts {
+	sections := map[string][]string{}
+	var current string
+	for _, line := range strings.Split(out, "\n") {
+		if name, ok := strings.CutPrefix(line, "@@"); ok {
+			current = name
+			continue
+		}
+		if strings.TrimSpace(line) != "" {
+			sections[current] = append(sections[current], line)
+		}
+	}
+	first := func(section string) string {
+		if lines := sections[section]; len(lines) > 0 {
+			return strings.TrimSpace(lines[0])
+		}
+		return ""
+	}
+
+	f := &Facts{Collected: time.Now().UTC().Truncate(time.Second), Hostname: first("hostname")}
+	rel := distro.ParseOSRelease(strings.NewReader(strings.Join(sections["os-release"], "\n")))
+	f.Distro, f.DistroLike, f.OS = rel.ID, rel.IDLike, rel.PrettyName
+	if f.Distro == "" {
+		f.Distro = "unknown"
+	}
+	if uname := strings.Fields(first("uname")); len(uname) == 2 {
+		f.Kernel, f.Arch = uname[0], uname[1]
+	}
+	f.CPUs, _ = strconv.Atoi(first("cpus"))
+	for _, line := range sections["meminfo"] {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		kb, _ := strconv.ParseInt(fields[1], 10, 64)
+		switch fields[0] {
+		case "MemTotal:":
+			f.Memory = kb * 1024
+		case "MemAvailable:":
+			f.MemoryAvailable = kb * 1024
+		}
+	}
+	// df -P: filesystem, 1024-blocks, used, available, capacity, mount point.
+	if df := strings.Fields(first("disk")); len(df) >= 4 {
+		total, _ := strconv.ParseInt(df[1], 10, 64)
+		free, _ := strconv.ParseInt(df[3], 10, 64)
+		f.Disk, f.DiskFree = total*1024, free*1024
+	}
+	f.Systemd = first("systemd") == "yes"
+	for _, line := range sections["servers"] {
+		service, state, _ := strings.Cut(strings.TrimSpace(line), " ")
+		f.WebServers = append(f.WebServers, WebServer{Name: webServerNames[service], Service: service, Active: state == "active"})
+	}
+	f.Ports = listeningPorts(sections["tcp"])
+	if certbot := strings.Fields(first("certbot")); len(certbot) > 0 {
+		f.Certbot = certbot[len(certbot)-1]
+	}
+	for _, line := range sections["stackroost"] {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "stackroost version "); ok {
+			f.Stackroost = v
+		}
+	}
+	if f.Stackroost == "" && len(sections["stackroost"]) > 0 {
+		f.Stackroost = "unknown"
+	}
+	return f
+}
+
+// listeningPorts extracts the listening ports from /proc/net/tcp{,6}, whose
+// local_address column is hex IP:port and whose st column is 0A for LISTEN.
+func listeningPorts(lines []string) []int {
+	seen := map[int]bool{}
+	var ports []int
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[3] != "0A" {
+			continue
+		}
+		_, hex, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		port, err := strconv.ParseInt(hex, 16, 32)
+		if err != nil || seen[int(port)] {
+			continue
+		}
+		seen[int(port)] = true
+		ports = append(ports, int(port))
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// saveFacts caches f in the config of a configured remote. Hosts that are
+// only in ~/.ssh/config have nowhere to keep them.
+func saveFacts(name string, f *Facts) error {
+	if viper.GetString("remotes."+name+".userhost") == "" {
+		return nil
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	// JSON is YAML, and decoding it as such keeps numbers as integers in the
+	// config file.
+	var m map[string]any
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	viper.Set("remotes."+name+".facts", m)
+	return nil
+}
+
+// CachedFacts returns the facts last gathered from a remote, or nil if there
+// are none.
+func CachedFacts(name string) (*Facts, error) {
+	cached := viper.Get("remotes." + name + ".facts")
+	if cached == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return nil, err
+	}
+	f := &Facts{}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("invalid cached facts for %s: %w", name, err)
+	}
+	return f, nil
+}
+
+// RemoteFacts pairs facts with the remote they describe.
+type RemoteFacts struct {
+	Remote string `json:"remote"`
+	*Facts
+}
+
+// Inspect gathers facts from the named remotes, several at a time, and
+// caches them. Remotes that cannot be reached are reported and left out.
+func Inspect(names []string, parallel int) ([]RemoteFacts, error) {
+	if parallel < 1 {
+		parallel = 1
+	}
+	results := make([]RemoteFacts, len(names))
+	errs := make([]error, len(names))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			t, err := Lookup(name)
+			if err == nil {
+				results[i].Facts, err = GatherFacts(t)
+			}
+			results[i].Remote, errs[i] = name, err
+		}()
+	}
+	wg.Wait()
+
+	var gathered []RemoteFacts
+	failed := 0
+	for i, r := range results {
+		if err := recordContact(r.Remote, errs[i]); err != nil {
+			return nil, err
+		}
+		if errs[i] != nil {
+			if len(names) == 1 {
+				if err := config.Save(); err != nil {
+					return nil, fmt.Errorf("%w\nrecording the failure in the config also failed: %v", errs[i], err)
+				}
+				return nil, errs[i]
+			}
+			logger.Error(errs[i].Error())
+			failed++
+			continue
+		}
+		if err := saveFacts(r.Remote, r.Facts); err != nil {
+			return nil, err
+		}
+		gathered = append(gathered, r)
+	}
+	if err := config.Save(); err != nil {
+		return nil, err
+	}
+	if failed > 0 {
+		return gathered, fmt.Errorf("could not gather facts from %d of %d remotes", failed, len(names))
+	}
+	return gathered, nil
+}
+
+// printFacts writes one table row per remote, or a JSON array.
+func printFacts(w io.Writer, facts []RemoteFacts, asJSON bool) error {
+	if asJSON {
+		if facts == nil {
+			facts = []RemoteFacts{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(facts)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REMOTE\tOS\tKERNEL\tCPUS\tMEMORY\tDISK FREE\tWEB SERVERS\tPORTS\tCERTBOT\tSTACKROOST\tCOLLECTED")
+	for _, r := range facts {
+		f := r.Facts
+		osName := f.OS
+		if osName == "" {
+			osName = f.Distro
+		}
+		var webServers, ports []string
+		for _, ws := range f.WebServers {
+			state := "inactive"
+			if ws.Active {
+				state = "active"
+			}
+			webServers = append(webServers, fmt.Sprintf("%s (%s)", ws.Name, state))
+		}
+		for _, p := range f.Ports {
+			ports = append(ports, strconv.Itoa(p))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s %s\t%d\t%s\t%s of %s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Remote, osName, f.Kernel, f.Arch, f.CPUs, formatBytes(f.Memory),
+			formatBytes(f.DiskFree), formatBytes(f.Disk), orNone(strings.Join(webServers, ", ")),
+			orNone(strings.Join(ports, ",")), orNone(f.Certbot), orNone(f.Stackroost),
+			f.Collected.Local().Format("2006-01-02 15:04"))
+	}
+	return tw.Flush()
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
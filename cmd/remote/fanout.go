@@ -0,0 +1,184 @@
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/viper"
+)
+
+// Tags returns the tags of a configured remote.
+func Tags(name string) []string {
+	return viper.GetStringSlice("remotes." + name + ".tags")
+}
+
+// Select returns the remotes matching selector, a comma-separated list of
+// tags that must all be present. A tag matches exactly (env=prod) or by its
+// value (prod matches env=prod).
+func Select(selector string) ([]string, error) {
+	var names []string
+	for _, name := range Names() {
+		if hasTags(Tags(name), strings.Split(selector, ",")) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no remotes tagged %s", selector)
+	}
+	return names, nil
+}
+
+func hasTags(tags, wanted []string) bool {
+	for _, want := range wanted {
+		found := false
+		for _, tag := range tags {
+			if _, value, ok := strings.Cut(tag, "="); tag == want || ok && value == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// hostResult is the outcome of a command on one remote of a fan-out.
+type hostResult struct {
+	name     string
+	exitCode int
+	duration time.Duration
+	err      error
+}
+
+// FanOutError is returned when a command failed on some of the remotes it
+// ran on.
+type FanOutError struct {
+	Failed, Total int
+}
+
+func (e *FanOutError) Error() string {
+	return fmt.Sprintf("command failed on %d of %d remotes", e.Failed, e.Total)
+}
+
+// ExitCode makes stackroost exit with status 1.
+func (e *FanOutError) ExitCode() int { return 1 }
+
+// FanOut runs command on the named remotes, at most parallel at a time.
+// Output lines are prefixed with the remote's name, and a summary of exit
+// codes and durations follows once every remote has finished.
+func FanOut(names []string, parallel int, command string, opts ExecOptions) error {
+	return fanOut(names, parallel, command, opts, nil)
+}
+
+// fanOut is FanOut with explain, if not nil, rewriting the error of each
+// remote before it is summarized.
+func fanOut(names []string, parallel int, command string, opts ExecOptions, explain func(error) error) error {
+	if parallel < 1 {
+		parallel = 1
+	}
+	results := make([]hostResult, len(names))
+	slots := make(chan struct{}, parallel)
+	var out sync.Mutex
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			prefix := color.New(color.FgCyan).Sprintf("[%s] ", name)
+			stdout := &prefixWriter{mu: &out, w: os.Stdout, prefix: prefix}
+			stderr := &prefixWriter{mu: &out, w: os.Stderr, prefix: prefix}
+			start := time.Now()
+			result := hostResult{name: name}
+			t, err := Lookup(name)
+			if err == nil {
+				result.exitCode, err = run(t, command, stdout, stderr, opts)
+				if explain != nil {
+					err = explain(err)
+				}
+			} else {
+				result.exitCode = -1
+			}
+			result.duration, result.err = time.Since(start), err
+			stdout.Flush()
+			stderr.Flush()
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+	return summarize(os.Stdout, results)
+}
+
+// summarize prints a table of results and returns a FanOutError if any
+// remote failed.
+func summarize(w io.Writer, results []hostResult) error {
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REMOTE\tEXIT\tDURATION\tERROR")
+	failed := 0
+	for _, r := range results {
+		exit, reason := "-", ""
+		if r.exitCode >= 0 {
+			exit = fmt.Sprint(r.exitCode)
+		}
+		if r.err != nil {
+			failed++
+			var exitErr *ExitError
+			if !errors.As(r.err, &exitErr) || exitErr.Reason != "" {
+				reason = r.err.Error()
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.name, exit, r.duration.Round(time.Millisecond), reason)
+	}
+	tw.Flush()
+	if failed > 0 {
+		return &FanOutError{Failed: failed, Total: len(results)}
+	}
+	return nil
+}
+
+// prefixWriter writes whole lines to w, each starting with prefix. Writers
+// sharing mu never interleave within a line.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		p.writeLine(p.buf[:i+1])
+		p.buf = p.buf[i+1:]
+	}
+}
+
+// Flush writes a final line that did not end in a newline.
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.writeLine(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) writeLine(line []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.w, "%s%s", p.prefix, line)
+}
@@ -0,0 +1,169 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"stackroost-cli/cmd/internal/config"
+	"stackroost-cli/cmd/internal/logger"
+	"stackroost-cli/cmd/internal/prompt"
+	"stackroost-cli/cmd/internal/system"
+)
+
+// knownHostsFile is the OpenSSH known_hosts file accepted host keys are
+// recorded in, so ssh and stackroost trust the same hosts.
+func knownHostsFile() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// HostKeyChangedError is returned when a host presents a different key from
+// the one recorded for it.
+type HostKeyChangedError struct {
+	Host     string
+	Recorded string
+	Received string
+}
+
+func (e *HostKeyChangedError) Error() string {
+	return fmt.Sprintf(`host key for %s has changed, someone could be intercepting the connection
+  recorded: %s
+  received: %s
+If the change is expected, remove the old key from %s and from the remote's fingerprint in the config`,
+		e.Host, e.Recorded, e.Received, knownHostsFile())
+}
+
+// hostKeyCallback verifies host keys against the fingerprint stored for the
+// named remote and ~/.ssh/known_hosts. Unknown hosts are trusted on first use
+// after confirmation, which records the key in both places.
+func hostKeyCallback(name string) ssh.HostKeyCallback {
+	return func(hostname string, addr net.Addr, key ssh.PublicKey) error {
+		configMu.Lock()
+		defer configMu.Unlock()
+		received := ssh.FingerprintSHA256(key)
+		recorded := viper.GetString("remotes." + name + ".fingerprint")
+		if recorded != "" && recorded != received {
+			return &HostKeyChangedError{Host: hostname, Recorded: recorded, Received: received}
+		}
+		known, err := checkKnownHosts(hostname, addr, key)
+		if err != nil {
+			return err
+		}
+		if recorded != "" {
+			return nil
+		}
+		if !known {
+			if err := confirmHostKey(hostname, key); err != nil {
+				return err
+			}
+		}
+		return recordFingerprint(name, received)
+	}
+}
+
+// checkKnownHosts reports whether key is listed for hostname in known_hosts.
+func checkKnownHosts(hostname string, addr net.Addr, key ssh.PublicKey) (bool, error) {
+	check, err := knownhosts.New(knownHostsFile())
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	err = check(hostname, addr, key)
+	var keyErr *knownhosts.KeyError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &keyErr):
+		for _, want := range keyErr.Want {
+			// A key of another type is not a conflict: the host has several.
+			if want.Key.Type() == key.Type() {
+				return false, &HostKeyChangedError{
+					Host:     hostname,
+					Recorded: ssh.FingerprintSHA256(want.Key),
+					Received: ssh.FingerprintSHA256(key),
+				}
+			}
+		}
+		return false, nil
+	}
+	return false, err
+}
+
+// confirmHostKey asks whether to trust an unknown host and appends its key to
+// known_hosts.
+func confirmHostKey(hostname string, key ssh.PublicKey) error {
+	fingerprint := ssh.FingerprintSHA256(key)
+	ok, err := prompt.Confirm(fmt.Sprintf("The authenticity of host %s can't be established.\n%s key fingerprint is %s.\nTrust it?", hostname, key.Type(), fingerprint))
+	if errors.Is(err, prompt.ErrNoTerminal) {
+		return fmt.Errorf("host key for %s (%s) is not known; run stackroost interactively once to trust it", hostname, fingerprint)
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("host key for %s was not trusted", hostname)
+	}
+	file := knownHostsFile()
+	existing, err := system.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(existing) > 0 && existing[len(existing)-1] != '\n' {
+		existing = append(existing, '\n')
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n"
+	if err := system.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	return system.WriteFile(file, append(existing, line...), 0600)
+}
+
+// recordFingerprint stores the host key fingerprint of a configured remote.
+func recordFingerprint(name, fingerprint string) error {
+	if viper.GetString("remotes."+name+".userhost") == "" {
+		return nil
+	}
+	logger.Info(fmt.Sprintf("Recording host key %s for remote %s", fingerprint, name))
+	viper.Set("remotes."+name+".fingerprint", fingerprint)
+	return config.Save()
+}
+
+// Trust connects to the remote just far enough to verify its host key,
+// asking to trust it if it is new.
+func Trust(name string) error {
+	t, err := Lookup(name)
+	if err != nil {
+		return err
+	}
+	verified := false
+	check := hostKeyCallback(name)
+	cfg := &ssh.ClientConfig{
+		User: t.User,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if err := check(hostname, remote, key); err != nil {
+				return err
+			}
+			verified = true
+			return nil
+		},
+	}
+	conn, err := dialConn(t, 0)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// No credentials are offered, so authentication is expected to fail once
+	// the host key has been checked.
+	if _, _, _, err := ssh.NewClientConn(conn, t.Addr(), cfg); err != nil && !verified {
+		return err
+	}
+	return nil
+}
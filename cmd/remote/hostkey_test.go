@@ -0,0 +1,107 @@
+package remote
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// tcpAddrConn reports a TCP remote address, which known_hosts checks need,
+// for a net.Pipe.
+type tcpAddrConn struct {
+	net.Conn
+}
+
+func (tcpAddrConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+}
+
+// handshakeTest connects to server as the configured remote name over
+// net.Pipe.
+func handshakeTest(t *testing.T, server *testServer, name string) error {
+	t.Helper()
+	target, err := Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	near, far := net.Pipe()
+	go server.serve(far)
+	client, err := handshake(tcpAddrConn{near}, target)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if got := askName(t, client); got != server.name {
+		t.Errorf("connected to %q, want %s", got, server.name)
+	}
+	return nil
+}
+
+func TestHostKeyTrustedOnFirstUse(t *testing.T) {
+	home := useTestConfig(t)
+	web := newTestServer(t, "web")
+	viper.Set("remotes.web.userhost", "deploy@web.example.com")
+
+	// Without a terminal to confirm on, an unknown key is refused.
+	err := handshakeTest(t, web, "web")
+	if err == nil || !strings.Contains(err.Error(), "is not known") {
+		t.Fatalf("unknown host key: got %v, want a not known error", err)
+	}
+	if got := viper.GetString("remotes.web.fingerprint"); got != "" {
+		t.Fatalf("fingerprint recorded for an unconfirmed key: %s", got)
+	}
+
+	// A key ssh already trusts is accepted and recorded for the remote.
+	line := knownhosts.Line([]string{knownhosts.Normalize("web.example.com:22")}, web.key.PublicKey())
+	if err := os.MkdirAll(filepath.Join(home, ".ssh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(knownHostsFile(), []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := handshakeTest(t, web, "web"); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if got := viper.GetString("remotes.web.fingerprint"); got != web.fingerprint() {
+		t.Errorf("recorded fingerprint %q, want %q", got, web.fingerprint())
+	}
+	saved, err := os.ReadFile(filepath.Join(home, ".stackroost.yaml"))
+	if err != nil || !strings.Contains(string(saved), web.fingerprint()) {
+		t.Errorf("fingerprint not saved to the config: %v\n%s", err, saved)
+	}
+
+	// From then on the recorded fingerprint is enough.
+	if err := os.Remove(knownHostsFile()); err != nil {
+		t.Fatal(err)
+	}
+	if err := handshakeTest(t, web, "web"); err != nil {
+		t.Errorf("second use: %v", err)
+	}
+}
+
+func TestHostKeyChanged(t *testing.T) {
+	useTestConfig(t)
+	web := newTestServer(t, "web")
+	impostor := newTestServer(t, "impostor")
+	viper.Set("remotes.web.userhost", "deploy@web.example.com")
+	viper.Set("remotes.web.fingerprint", web.fingerprint())
+
+	err := handshakeTest(t, impostor, "web")
+	var changed *HostKeyChangedError
+	if !errors.As(err, &changed) {
+		t.Fatalf("got %v, want a HostKeyChangedError", err)
+	}
+	if changed.Recorded != web.fingerprint() || changed.Received != impostor.fingerprint() {
+		t.Errorf("recorded %s received %s, want %s and %s",
+			changed.Recorded, changed.Received, web.fingerprint(), impostor.fingerprint())
+	}
+	if got := viper.GetString("remotes.web.fingerprint"); got != web.fingerprint() {
+		t.Errorf("fingerprint changed to %s", got)
+	}
+}
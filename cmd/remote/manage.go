@@ -0,0 +1,262 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+	"stackroost-cli/cmd/internal/config"
+	"stackroost-cli/cmd/internal/logger"
+)
+
+// Edit holds the connection settings remote set changes; nil fields are left
+// as they are.
+type Edit struct {
+	User *string
+	Host *string
+	Port *int
+	Key  *string
+}
+
+// Set changes the connection settings of a remote. Moving it to another host
+// or port forgets what was learned about the old one and checks the new host
+// key.
+func Set(name string, e Edit) error {
+	old, err := configured(name)
+	if err != nil {
+		return err
+	}
+	t := old
+	if e.User != nil {
+		t.User = *e.User
+	}
+	if e.Host != nil {
+		t.Host = *e.Host
+	}
+	if e.Port != nil {
+		if *e.Port <= 0 || *e.Port > 65535 {
+			return fmt.Errorf("invalid port: %d", *e.Port)
+		}
+		t.Port = *e.Port
+	}
+	if t.User == "" || t.Host == "" {
+		return fmt.Errorf("user and host cannot be empty")
+	}
+	// The old connection settings no longer apply to a running master.
+	if _, err := StopControl(old); err != nil {
+		return err
+	}
+	userhost := formatUserHost(t.User, t.Host, t.Port)
+	viper.Set("remotes."+name+".userhost", userhost)
+	if e.Key != nil {
+		viper.Set("remotes."+name+".key", *e.Key)
+	}
+	moved := t.Host != old.Host || t.Port != old.Port
+	if moved {
+		if err := forgetHost(name); err != nil {
+			return err
+		}
+	}
+	logger.Info(fmt.Sprintf("Writing configuration for remote %s", name))
+	if err := config.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("Updated remote %s: %s\n", name, userhost)
+	if !moved {
+		return nil
+	}
+	if err := Trust(name); err != nil {
+		var changed *HostKeyChangedError
+		if errors.As(err, &changed) {
+			return err
+		}
+		logger.Info(fmt.Sprintf("Could not check the host key of %s now (%v); it will be verified on first connection", name, err))
+	}
+	return nil
+}
+
+// forgetHost drops what was learned about a remote's old address: its host
+// key, facts and connection history.
+func forgetHost(name string) error {
+	for _, field := range []string{"fingerprint", "facts", "last_seen", "last_error"} {
+		if err := config.Unset("remotes." + name + "." + field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename gives a remote a new name, keeping its settings, and updates the
+// remotes that use it as a jump host.
+func Rename(oldName, newName string) error {
+	t, err := configured(oldName)
+	if err != nil {
+		return err
+	}
+	if viper.GetString("remotes."+newName+".userhost") != "" {
+		return fmt.Errorf("remote %s already exists", newName)
+	}
+	if _, err := StopControl(t); err != nil {
+		return err
+	}
+	viper.Set("remotes."+newName, viper.Get("remotes."+oldName))
+	if err := config.Unset("remotes." + oldName); err != nil {
+		return err
+	}
+	for _, name := range Names() {
+		jump := viper.GetStringSlice("remotes." + name + ".jump")
+		renamed := false
+		for i, hop := range jump {
+			if hop == oldName {
+				jump[i], renamed = newName, true
+			}
+		}
+		if renamed {
+			logger.Info(fmt.Sprintf("Updating jump hosts of remote %s", name))
+			viper.Set("remotes."+name+".jump", jump)
+		}
+	}
+	logger.Info(fmt.Sprintf("Renaming remote %s to %s", oldName, newName))
+	return config.Save()
+}
+
+// configured returns the target of a remote recorded in the config, without
+// the ~/.ssh/config fallback of Lookup.
+func configured(name string) (Target, error) {
+	if viper.GetString("remotes."+name+".userhost") == "" {
+		return Target{}, fmt.Errorf("unknown remote: %s", name)
+	}
+	return Lookup(name)
+}
+
+// JumpUsers lists the remotes that connect through name.
+func JumpUsers(name string) []string {
+	var users []string
+	for _, other := range Names() {
+		for _, hop := range viper.GetStringSlice("remotes." + other + ".jump") {
+			if hop == name {
+				users = append(users, other)
+				break
+			}
+		}
+	}
+	return users
+}
+
+// Probe is what remote test found out about a remote.
+type Probe struct {
+	// Connect is the time to open the TCP connection, through any jump
+	// hosts; Login covers the SSH handshake and authentication; RoundTrip
+	// is running true once logged in.
+	Connect   time.Duration
+	Login     time.Duration
+	RoundTrip time.Duration
+	Method    string
+	HostKey   ssh.PublicKey
+}
+
+// Test connects to t afresh, logs in and runs true, timing each step.
+func Test(t Target) (*Probe, error) {
+	p := &Probe{}
+	start := time.Now()
+	conn, err := dialConn(t, 0)
+	if err != nil {
+		return nil, err
+	}
+	p.Connect = time.Since(start)
+
+	cfg := clientConfig(t)
+	cfg.Auth = authMethods(t, func(method string) { p.Method = method })
+	check := cfg.HostKeyCallback
+	cfg.HostKeyCallback = func(hostname string, addr net.Addr, key ssh.PublicKey) error {
+		p.HostKey = key
+		return check(hostname, addr, key)
+	}
+	start = time.Now()
+	client, err := handshakeWith(conn, t, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	p.Login = time.Since(start)
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	defer session.Close()
+	start = time.Now()
+	if err := session.Run("true"); err != nil {
+		return nil, fmt.Errorf("running true on %s failed: %w", t.Name, err)
+	}
+	p.RoundTrip = time.Since(start)
+	return p, nil
+}
+
+// Print writes the probe results for t.
+func (p *Probe) Print(w io.Writer, t Target) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Remote:\t%s (%s)\n", t.Name, formatUserHost(t.User, t.Host, t.Port))
+	if len(t.Jump) > 0 {
+		fmt.Fprintf(tw, "Via:\t%s\n", strings.Join(t.Jump, " -> "))
+	}
+	fmt.Fprintf(tw, "Connect:\t%s\n", p.Connect.Round(time.Millisecond))
+	fmt.Fprintf(tw, "Login:\t%s\n", p.Login.Round(time.Millisecond))
+	fmt.Fprintf(tw, "Round trip:\t%s\n", p.RoundTrip.Round(time.Millisecond))
+	fmt.Fprintf(tw, "Auth method:\t%s\n", p.Method)
+	fmt.Fprintf(tw, "Host key:\t%s %s\n", p.HostKey.Type(), ssh.FingerprintSHA256(p.HostKey))
+	tw.Flush()
+}
+
+// recordContact notes the outcome of reaching a configured remote, for the
+// status shown by remote list. The config still has to be saved.
+func recordContact(name string, err error) error {
+	if viper.GetString("remotes."+name+".userhost") == "" {
+		return nil
+	}
+	if err != nil {
+		viper.Set("remotes."+name+".last_error", err.Error())
+		return nil
+	}
+	viper.Set("remotes."+name+".last_seen", time.Now().UTC().Format(time.RFC3339))
+	return config.Unset("remotes." + name + ".last_error")
+}
+
+// printRemotes writes the table shown by remote list.
+func printRemotes(w io.Writer, names []string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tADDRESS\tKEY\tTAGS\tSTATUS\tLAST SEEN")
+	for _, name := range names {
+		prefix := "remotes." + name + "."
+		status, seen := "-", "never"
+		if last, err := time.Parse(time.RFC3339, viper.GetString(prefix+"last_seen")); err == nil {
+			status, seen = "ok", ago(last)
+		}
+		if viper.GetString(prefix+"last_error") != "" {
+			status = "failing"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", name, viper.GetString(prefix+"userhost"),
+			orNone(viper.GetString(prefix+"key")), orNone(strings.Join(Tags(name), ",")), status, seen)
+	}
+	return tw.Flush()
+}
+
+// ago describes how long ago t was, roughly.
+func ago(t time.Time) string {
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+}
@@ -0,0 +1,76 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// RunOn runs stackroost with args on target, a remote name or a tag
+// selector, using the stackroost binary installed there. A single remote
+// gets the local terminal; a group runs in parallel like remote exec --group.
+func RunOn(target string, args []string) error {
+	command := stackroostCommand(args)
+
+	if t, err := Lookup(target); err == nil {
+		tty := term.IsTerminal(int(os.Stdin.Fd()))
+		_, err := run(t, command, os.Stdout, os.Stderr, ExecOptions{TTY: tty})
+		return notInstalled(err)
+	}
+	names, err := Select(target)
+	if err != nil {
+		return fmt.Errorf("%s is neither a remote nor a tag of any remote", target)
+	}
+	return fanOut(names, 10, command, ExecOptions{}, notInstalled)
+}
+
+// stackroostCommand returns the shell command running stackroost with args.
+func stackroostCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return "stackroost " + strings.Join(quoted, " ")
+}
+
+// notInstalled explains the exit status the shell uses for a missing binary.
+// The explanation is the error's reason, so group runs show it per remote.
+func notInstalled(err error) error {
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) && exitErr.Status == 127 && exitErr.Reason == "" {
+		return &ExitError{Remote: exitErr.Remote, Status: exitErr.Status,
+			Reason: fmt.Sprintf("could not run stackroost, which is not installed there (or not in its PATH); install it with stackroost remote bootstrap %s", exitErr.Remote)}
+	}
+	return err
+}
+
+// StripFlag removes every occurrence of a flag and its value from args, in
+// the --name value and --name=value forms.
+func StripFlag(args []string, name string) []string {
+	var kept []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--"+name:
+			i++
+		case strings.HasPrefix(args[i], "--"+name+"="):
+		default:
+			kept = append(kept, args[i])
+		}
+	}
+	return kept
+}
+
+// StripSwitch removes every occurrence of a boolean flag from args, in the
+// --name and --name=value forms.
+func StripSwitch(args []string, name string) []string {
+	var kept []string
+	for _, arg := range args {
+		if arg != "--"+name && !strings.HasPrefix(arg, "--"+name+"=") {
+			kept = append(kept, arg)
+		}
+	}
+	return kept
+}
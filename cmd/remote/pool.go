@@ -0,0 +1,99 @@
+package remote
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// pool keeps one authenticated client per host for the rest of the
+// invocation, so commands, transfers and fan-outs that reach the same host
+// several times open sessions on a single connection.
+var pool = struct {
+	sync.Mutex
+	clients map[string]*pooled
+}{clients: map[string]*pooled{}}
+
+// pooled is a client being connected or ready for use; ready is closed once
+// client or err is set.
+type pooled struct {
+	ready  chan struct{}
+	client *ssh.Client
+	err    error
+}
+
+// poolKey identifies everything that makes two connections to a host
+// interchangeable.
+func poolKey(t Target) string {
+	return fmt.Sprintf("%s\x00%s@%s\x00%s\x00%s", t.Name, t.User, t.Addr(), t.Key, strings.Join(t.Jump, ","))
+}
+
+// Connect returns a client for t, connecting on first use. The client is
+// shared and must not be closed; CloseConnections closes it on exit.
+func Connect(t Target) (*ssh.Client, error) {
+	return connect(t, 0)
+}
+
+func connect(t Target, depth int) (*ssh.Client, error) {
+	if depth == 0 {
+		// Before joining the pool, where a loop would wait on itself.
+		if err := checkJumps(t); err != nil {
+			return nil, err
+		}
+	}
+	key := poolKey(t)
+	pool.Lock()
+	if p, ok := pool.clients[key]; ok {
+		pool.Unlock()
+		<-p.ready
+		return p.client, p.err
+	}
+	p := &pooled{ready: make(chan struct{})}
+	pool.clients[key] = p
+	pool.Unlock()
+
+	if depth == 0 && controlPersist() > 0 {
+		p.client, p.err = controlClient(t)
+	} else {
+		p.client, p.err = dial(t, depth)
+	}
+	close(p.ready)
+	if p.err != nil {
+		// Later attempts try again rather than repeat the failure.
+		forget(key, p)
+		return nil, p.err
+	}
+	go func() {
+		// Drop connections the server has closed.
+		p.client.Wait()
+		forget(key, p)
+	}()
+	return p.client, nil
+}
+
+func forget(key string, p *pooled) {
+	pool.Lock()
+	defer pool.Unlock()
+	if pool.clients[key] == p {
+		delete(pool.clients, key)
+	}
+}
+
+// CloseConnections closes every pooled client. Control masters are left
+// running for the next invocation.
+func CloseConnections() {
+	pool.Lock()
+	defer pool.Unlock()
+	for key, p := range pool.clients {
+		select {
+		case <-p.ready:
+			if p.client != nil {
+				p.client.Close()
+			}
+		default:
+		}
+		delete(pool.clients, key)
+	}
+}
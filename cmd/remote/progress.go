@@ -0,0 +1,61 @@
+package remote
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// progress reports the bytes copied for one file on stderr: a live line on a
+// terminal, otherwise one line once the file is done.
+type progress struct {
+	name    string
+	total   int64
+	written int64
+	live    bool
+	shown   time.Time
+}
+
+func newProgress(name string, total int64) *progress {
+	return &progress{name: name, total: total, live: term.IsTerminal(int(os.Stderr.Fd()))}
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	if p.live && time.Since(p.shown) > 100*time.Millisecond {
+		p.shown = time.Now()
+		fmt.Fprintf(os.Stderr, "\r\033[K%s", p.line())
+	}
+	return len(b), nil
+}
+
+// done prints the final line for the file.
+func (p *progress) done() {
+	if p.live {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+	fmt.Fprintln(os.Stderr, p.line())
+}
+
+func (p *progress) line() string {
+	percent := 100
+	if p.total > 0 {
+		percent = int(p.written * 100 / p.total)
+	}
+	return fmt.Sprintf("%s  %s / %s  %3d%%", p.name, formatBytes(p.written), formatBytes(p.total), percent)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
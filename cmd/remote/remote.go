@@ -5,14 +5,14 @@ Copyright © 2025 Stackroost CLI
 package remote
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"golang.org/x/crypto/ssh"
+	"stackroost-cli/cmd/internal/config"
 	"stackroost-cli/cmd/internal/logger"
 )
 
@@ -24,103 +24,541 @@ var remoteCmd = &cobra.Command{
 }
 
 var remoteAddCmd = &cobra.Command{
-	Use:   "add [name] [user@host] --key [keyfile]",
+	Use:   "add [name] [user@host[:port]] --key [keyfile]",
 	Short: "Add a remote server",
 	Args:  cobra.ExactArgs(2),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		name := args[0]
 		userHost := args[1]
 		key, _ := cmd.Flags().GetString("key")
-		viper.Set("remotes."+name+".userhost", userHost)
-		viper.Set("remotes."+name+".key", key)
-		logger.Info(fmt.Sprintf("Writing configuration for remote %s", name))
-		viper.WriteConfig()
+		port, _ := cmd.Flags().GetInt("port")
+		if port != 0 {
+			t, err := parseUserHost(userHost)
+			if err != nil {
+				return err
+			}
+			userHost = formatUserHost(t.User, t.Host, port)
+		}
+		jump, _ := cmd.Flags().GetStringSlice("jump")
+		tags, _ := cmd.Flags().GetStringSlice("tag")
+		if err := Add(name, Spec{UserHost: userHost, Key: key, Jump: jump, Tags: tags}); err != nil {
+			return err
+		}
+		if err := Trust(name); err != nil {
+			var changed *HostKeyChangedError
+			if errors.As(err, &changed) {
+				return err
+			}
+			logger.Info(fmt.Sprintf("Could not check the host key of %s now (%v); it will be verified on first connection", name, err))
+		}
 		fmt.Printf("Added remote %s: %s\n", name, userHost)
+		return nil
 	},
 }
 
 var remoteListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List remote servers",
-	Run: func(cmd *cobra.Command, args []string) {
-		remotes := viper.GetStringMap("remotes")
-		for name := range remotes {
-			userhost := viper.GetString("remotes." + name + ".userhost")
-			fmt.Printf("%s: %s\n", name, userhost)
+	Long: `List remote servers with their key, tags and status. The status and last-seen
+time come from the last remote test or remote facts run.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printRemotes(os.Stdout, Names())
+	},
+}
+
+var remoteRemoveCmd = &cobra.Command{
+	Use:   "remove [name]",
+	Short: "Remove a remote server",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		t, err := configured(args[0])
+		if err != nil {
+			return err
+		}
+		if users := JumpUsers(args[0]); len(users) > 0 {
+			logger.Info(fmt.Sprintf("%s is still the jump host of %s", args[0], strings.Join(users, ", ")))
+		}
+		if _, err := StopControl(t); err != nil {
+			return err
+		}
+		if err := Remove(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("Removed remote %s\n", args[0])
+		return nil
+	},
+}
+
+var remoteSetCmd = &cobra.Command{
+	Use:   "set [name] [--user user] [--host host] [--port port] [--key keyfile]",
+	Short: "Change how to connect to a remote server",
+	Long: `Change the user, host, port or key of a remote. Moving a remote to another host
+or port checks the new host key and forgets the cached facts of the old one.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var e Edit
+		flags := cmd.Flags()
+		if flags.Changed("user") {
+			user, _ := flags.GetString("user")
+			e.User = &user
+		}
+		if flags.Changed("host") {
+			host, _ := flags.GetString("host")
+			e.Host = &host
+		}
+		if flags.Changed("port") {
+			port, _ := flags.GetInt("port")
+			e.Port = &port
+		}
+		if flags.Changed("key") {
+			key, _ := flags.GetString("key")
+			e.Key = &key
+		}
+		if e == (Edit{}) {
+			return fmt.Errorf("nothing to change; use --user, --host, --port or --key")
+		}
+		return Set(args[0], e)
+	},
+}
+
+var remoteRenameCmd = &cobra.Command{
+	Use:   "rename [name] [new-name]",
+	Short: "Rename a remote server",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := Rename(args[0], args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Renamed remote %s to %s\n", args[0], args[1])
+		return nil
+	},
+}
+
+var remoteTestCmd = &cobra.Command{
+	Use:   "test [name]",
+	Short: "Check that a remote server can be reached and logged into",
+	Long: `Open a new connection to a remote, log in and run true, then report how long
+each step took, the authentication method that worked and the host key.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		t, err := Lookup(args[0])
+		if err != nil {
+			return err
 		}
+		probe, err := Test(t)
+		if rerr := recordContact(args[0], err); rerr != nil {
+			return rerr
+		}
+		if serr := config.Save(); serr != nil {
+			return serr
+		}
+		if err != nil {
+			return err
+		}
+		probe.Print(os.Stdout, t)
+		return nil
 	},
 }
 
 var remoteExecCmd = &cobra.Command{
 	Use:   "exec [name] [command]",
 	Short: "Execute command on remote server",
-	Args:  cobra.MinimumNArgs(2),
-	Run: func(cmd *cobra.Command, args []string) {
+	Long: `Execute a command on one remote, or with --group on every remote carrying the
+given tag, several at a time. Tags are matched exactly (env=prod) or by value
+(prod); join several with commas to require all of them (env=prod,role=web).`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if group, _ := cmd.Flags().GetString("group"); group != "" {
+			return cobra.MinimumNArgs(1)(cmd, args)
+		}
+		return cobra.MinimumNArgs(2)(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if group, _ := cmd.Flags().GetString("group"); group != "" {
+			if tty, _ := cmd.Flags().GetBool("tty"); tty {
+				return fmt.Errorf("--tty cannot be combined with --group")
+			}
+			parallel, _ := cmd.Flags().GetInt("parallel")
+			names, err := Select(group)
+			if err != nil {
+				return err
+			}
+			return FanOut(names, parallel, strings.Join(args, " "), execOptions(cmd))
+		}
 		name := args[0]
 		command := strings.Join(args[1:], " ")
-		userhost := viper.GetString("remotes." + name + ".userhost")
-		keyfile := viper.GetString("remotes." + name + ".key")
-		executeRemoteCommand(userhost, keyfile, command)
+		t, err := Lookup(name)
+		if err != nil {
+			return err
+		}
+		return executeRemoteCommand(t, command, execOptions(cmd))
+	},
+}
+
+var remoteSSHCmd = &cobra.Command{
+	Use:   "ssh [name]",
+	Short: "Open an interactive shell on a remote server",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		t, err := Lookup(args[0])
+		if err != nil {
+			return err
+		}
+		return Shell(t)
+	},
+}
+
+var remotePushCmd = &cobra.Command{
+	Use:   "push [name] [local] [remote-path]",
+	Short: "Copy files to a remote server over SFTP",
+	Long: `Copy a local file or directory (recursively) to a remote server, keeping
+permissions and modification times. Files whose checksum already matches are
+skipped. With --sudo the files are staged in /tmp and copied into place as root,
+for destinations such as /etc/nginx.`,
+	Args: cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		t, err := Lookup(args[0])
+		if err != nil {
+			return err
+		}
+		return Push(t, args[1], args[2], transferOptions(cmd))
 	},
 }
 
+var remotePullCmd = &cobra.Command{
+	Use:   "pull [name] [remote-path] [local]",
+	Short: "Copy files from a remote server over SFTP",
+	Long: `Copy a remote file or directory (recursively) to the local machine, keeping
+permissions and modification times. Files whose checksum already matches are
+skipped. With --sudo, files only root can read are copied out with sudo first.`,
+	Args: cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		t, err := Lookup(args[0])
+		if err != nil {
+			return err
+		}
+		return Pull(t, args[1], args[2], transferOptions(cmd))
+	},
+}
+
+var remoteImportCmd = &cobra.Command{
+	Use:   "import [host...]",
+	Short: "Import remotes from ~/.ssh/config",
+	Long: `Create a remote for every Host entry in the OpenSSH client config (or only the
+named ones), resolving HostName, User, Port and IdentityFile the way ssh does,
+including Include files and wildcard Host blocks.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, _ := cmd.Flags().GetString("file")
+		if file == "" {
+			file = sshConfigFile()
+		}
+		return Import(file, args)
+	},
+}
+
+var remoteBootstrapCmd = &cobra.Command{
+	Use:   "bootstrap [name]",
+	Short: "Install stackroost on a remote server",
+	Long: `Upload stackroost to a remote server over SFTP and install it to
+/usr/local/bin, then check that the server can be managed with it: a supported
+distribution, systemd, and which web servers are installed. The running
+executable is uploaded unless --binary names a build for the remote's
+architecture.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		t, err := Lookup(args[0])
+		if err != nil {
+			return err
+		}
+		binary, _ := cmd.Flags().GetString("binary")
+		return Bootstrap(t, binary)
+	},
+}
+
+var remoteFactsCmd = &cobra.Command{
+	Use:   "facts [name...]",
+	Short: "Inspect remote servers",
+	Long: `Connect to remote servers and report their distribution, kernel, CPUs, memory,
+disk, web servers, listening ports and the certbot and stackroost versions
+installed. The facts are cached in the config; --cached shows them without
+connecting. With no names, every remote is inspected.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		names := args
+		if group, _ := cmd.Flags().GetString("group"); group != "" {
+			selected, err := Select(group)
+			if err != nil {
+				return err
+			}
+			names = append(names, selected...)
+		} else if len(names) == 0 {
+			names = Names()
+		}
+		asJSON, _ := cmd.Flags().GetBool("json")
+		if cached, _ := cmd.Flags().GetBool("cached"); cached {
+			var facts []RemoteFacts
+			for _, name := range names {
+				f, err := CachedFacts(name)
+				if err != nil {
+					return err
+				}
+				if f == nil {
+					logger.Info(fmt.Sprintf("No facts cached for %s yet", name))
+					continue
+				}
+				facts = append(facts, RemoteFacts{Remote: name, Facts: f})
+			}
+			return printFacts(os.Stdout, facts, asJSON)
+		}
+		parallel, _ := cmd.Flags().GetInt("parallel")
+		facts, err := Inspect(names, parallel)
+		if len(facts) > 0 || asJSON {
+			if perr := printFacts(os.Stdout, facts, asJSON); perr != nil {
+				return perr
+			}
+		}
+		return err
+	},
+}
+
+var remoteDisconnectCmd = &cobra.Command{
+	Use:   "disconnect [name...]",
+	Short: "Close connections kept open by ssh.control_persist",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		names := args
+		if len(names) == 0 {
+			names = Names()
+		}
+		for _, name := range names {
+			t, err := Lookup(name)
+			if err != nil {
+				return err
+			}
+			stopped, err := StopControl(t)
+			if err != nil {
+				return err
+			}
+			if stopped {
+				fmt.Printf("Closed connection to %s\n", name)
+			}
+		}
+		return nil
+	},
+}
+
+var remoteControlMasterCmd = &cobra.Command{
+	Use:    "control-master [name]",
+	Short:  "Hold a connection open for later invocations",
+	Hidden: true,
+	Args:   cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		socket, _ := cmd.Flags().GetString("socket")
+		return ServeControl(args[0], socket)
+	},
+}
+
+var remoteConfigPathCmd = &cobra.Command{
+	Use:    "config-path",
+	Short:  "Print the config file stackroost uses",
+	Hidden: true,
+	Args:   cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := config.Path()
+		if err != nil {
+			return err
+		}
+		// Rollouts look for this line among the banner and log output.
+		fmt.Println(configPathPrefix + path)
+		return nil
+	},
+}
+
+var remoteTunnelCmd = &cobra.Command{
+	Use:   "tunnel [name] -L [bind:]port:host:hostport | -R [bind:]port:host:hostport",
+	Short: "Forward ports to or from a remote server",
+	Long: `Forward ports over SSH until Ctrl-C, as ssh -L and -R do. -L listens locally and
+connects from the remote, for endpoints only the remote can reach such as the
+Caddy admin API (-L 2019) or a database (-L 5433:localhost:5432). -R listens on
+the remote and connects from here. Both can be repeated, and the tunnel
+reconnects by itself if the connection drops.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		t, err := Lookup(args[0])
+		if err != nil {
+			return err
+		}
+		var forwards []Forward
+		for _, flag := range []string{"local", "remote"} {
+			specs, _ := cmd.Flags().GetStringArray(flag)
+			for _, spec := range specs {
+				f, err := ParseForward(spec, flag == "remote")
+				if err != nil {
+					return err
+				}
+				forwards = append(forwards, f)
+			}
+		}
+		if len(forwards) == 0 {
+			return fmt.Errorf("nothing to forward; use -L or -R")
+		}
+		return Tunnel(t, forwards)
+	},
+}
+
+func execOptions(cmd *cobra.Command) ExecOptions {
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	tty, _ := cmd.Flags().GetBool("tty")
+	return ExecOptions{Timeout: timeout, TTY: tty}
+}
+
+func transferOptions(cmd *cobra.Command) TransferOptions {
+	sudo, _ := cmd.Flags().GetBool("sudo")
+	return TransferOptions{Sudo: sudo}
+}
+
 func AddRemoteCmd(root *cobra.Command) {
 	root.AddCommand(remoteCmd)
 
 	remoteCmd.AddCommand(remoteAddCmd)
 	remoteCmd.AddCommand(remoteListCmd)
 	remoteCmd.AddCommand(remoteExecCmd)
+	remoteCmd.AddCommand(remoteImportCmd)
+	remoteCmd.AddCommand(remoteSSHCmd)
+	remoteCmd.AddCommand(remotePushCmd)
+	remoteCmd.AddCommand(remotePullCmd)
+	remoteCmd.AddCommand(remoteBootstrapCmd)
+	remoteCmd.AddCommand(remoteFactsCmd)
+	remoteCmd.AddCommand(remoteDisconnectCmd)
+	remoteCmd.AddCommand(remoteTunnelCmd)
+	remoteCmd.AddCommand(remoteRemoveCmd)
+	remoteCmd.AddCommand(remoteSetCmd)
+	remoteCmd.AddCommand(remoteRenameCmd)
+	remoteCmd.AddCommand(remoteTestCmd)
+	remoteCmd.AddCommand(remoteControlMasterCmd)
+	remoteCmd.AddCommand(remoteConfigPathCmd)
 
-	remoteAddCmd.Flags().String("key", "", "SSH key file")
+	remoteAddCmd.Flags().String("key", "", "SSH key file (optional with ssh-agent)")
+	remoteImportCmd.Flags().String("file", "", "OpenSSH config file (default ~/.ssh/config)")
+	remoteAddCmd.Flags().StringSlice("tag", nil, "Tag such as env=prod or role=web, for selecting remotes with --group (repeatable)")
+	remoteExecCmd.Flags().StringP("group", "g", "", "Run on every remote matching these tags instead of a named remote")
+	remoteCmd.PersistentFlags().DurationVar(&connectTimeout, "connect-timeout", connectTimeout, "Give up connecting to a host after this long")
+	remoteExecCmd.Flags().Duration("timeout", 0, "Stop the command after this long, e.g. 30s or 5m (exit status 124)")
+	remoteExecCmd.Flags().BoolP("tty", "t", false, "Allocate a terminal, for commands such as sudo that prompt")
+	remotePushCmd.Flags().Bool("sudo", false, "Install into destinations the remote user cannot write, using passwordless sudo")
+	remotePullCmd.Flags().Bool("sudo", false, "Read files the remote user cannot, using passwordless sudo")
+	remoteExecCmd.Flags().Int("parallel", 10, "Maximum number of remotes to run on at once with --group")
+	remoteAddCmd.Flags().StringSlice("jump", nil, "Jump host to connect through, like ssh -J (repeatable, in order)")
+	remoteBootstrapCmd.Flags().String("binary", "", "stackroost build to install (default: this executable)")
+	remoteFactsCmd.Flags().StringP("group", "g", "", "Inspect every remote matching these tags")
+	remoteFactsCmd.Flags().Int("parallel", 10, "Maximum number of remotes to inspect at once")
+	remoteFactsCmd.Flags().Bool("json", false, "Print the facts as JSON")
+	remoteFactsCmd.Flags().Bool("cached", false, "Show the facts cached by the last run instead of connecting")
+	remoteTunnelCmd.Flags().StringArrayP("local", "L", nil, "Forward a local port to host:hostport as seen from the remote (repeatable)")
+	remoteTunnelCmd.Flags().StringArrayP("remote", "R", nil, "Forward a port on the remote to host:hostport as seen from here (repeatable)")
+	remoteSetCmd.Flags().String("user", "", "User to log in as")
+	remoteSetCmd.Flags().String("host", "", "Host name or address")
+	remoteSetCmd.Flags().Int("port", 22, "SSH port")
+	remoteSetCmd.Flags().String("key", "", "SSH key file (empty to rely on ssh-agent)")
+	remoteControlMasterCmd.Flags().String("socket", "", "Unix socket to serve the connection on")
+	remoteAddCmd.Flags().Int("port", 0, "SSH port (default 22, or the port in user@host:port)")
 }
 
-func executeRemoteCommand(userhost, keyfile, command string) {
-	key, err := ioutil.ReadFile(keyfile)
-	if err != nil {
-		fmt.Println("Unable to read private key:", err)
-		return
-	}
+// Names lists the configured remotes.
+func Names() []string {
+	return config.Names("remotes", "userhost")
+}
 
-	signer, err := ssh.ParsePrivateKey(key)
-	if err != nil {
-		fmt.Println("Unable to parse private key:", err)
-		return
-	}
+// Spec is a remote as recorded in the config.
+type Spec struct {
+	UserHost string
+	Key      string
+	// Jump lists the hosts to tunnel through to reach the remote, in order.
+	Jump []string
+	// Tags group remotes, e.g. env=prod or role=web.
+	Tags []string
+}
 
-	parts := strings.Split(userhost, "@")
-	if len(parts) != 2 {
-		fmt.Println("Invalid user@host format")
-		return
+// Add records a remote server in the config. Re-adding a remote at a new
+// host or port forgets the host key and facts recorded for the old one.
+func Add(name string, spec Spec) error {
+	if old, err := configured(name); err == nil {
+		t, err := parseUserHost(spec.UserHost)
+		if err != nil {
+			return err
+		}
+		if t.Host != old.Host || t.Port != old.Port {
+			if _, err := StopControl(old); err != nil {
+				return err
+			}
+			if err := forgetHost(name); err != nil {
+				return err
+			}
+		}
+	}
+	viper.Set("remotes."+name+".userhost", spec.UserHost)
+	viper.Set("remotes."+name+".key", spec.Key)
+	if err := setList("remotes."+name+".jump", spec.Jump); err != nil {
+		return err
 	}
-	user := parts[0]
-	host := parts[1]
-
-	config := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	if err := setList("remotes."+name+".tags", spec.Tags); err != nil {
+		return err
 	}
+	logger.Info(fmt.Sprintf("Writing configuration for remote %s", name))
+	return config.Save()
+}
 
-	client, err := ssh.Dial("tcp", host+":22", config)
+// Import records Host entries from an OpenSSH config file as remotes. Remotes
+// that already exist are left untouched.
+func Import(file string, aliases []string) error {
+	sshConfig, err := loadSSHConfig(file)
 	if err != nil {
-		fmt.Println("Failed to dial:", err)
-		return
+		return err
 	}
-	defer client.Close()
+	if len(aliases) == 0 {
+		aliases = sshConfig.hosts()
+	}
+	imported := 0
+	for _, alias := range aliases {
+		if !sshConfig.defines(alias) {
+			return fmt.Errorf("no Host entry for %s in %s", alias, file)
+		}
+		if viper.GetString("remotes."+alias+".userhost") != "" {
+			logger.Info(fmt.Sprintf("Remote %s already exists, skipping", alias))
+			continue
+		}
+		t, err := sshConfig.target(alias)
+		if err != nil {
+			return err
+		}
+		userhost := formatUserHost(t.User, t.Host, t.Port)
+		viper.Set("remotes."+alias+".userhost", userhost)
+		viper.Set("remotes."+alias+".key", t.Key)
+		if err := setList("remotes."+alias+".jump", t.Jump); err != nil {
+			return err
+		}
+		fmt.Printf("Imported remote %s: %s\n", alias, userhost)
+		imported++
+	}
+	if imported == 0 {
+		return nil
+	}
+	logger.Info(fmt.Sprintf("Writing configuration for %d imported remotes", imported))
+	return config.Save()
+}
 
-	session, err := client.NewSession()
-	if err != nil {
-		fmt.Println("Failed to create session:", err)
-		return
+// setList stores values under key, leaving the key out of the config file
+// when there are none.
+func setList(key string, values []string) error {
+	if len(values) == 0 {
+		return config.Unset(key)
 	}
-	defer session.Close()
+	viper.Set(key, values)
+	return nil
+}
 
-	session.Stdout = os.Stdout
-	session.Stderr = os.Stderr
-	err = session.Run(command)
-	if err != nil {
-		fmt.Println("Failed to run command:", err)
+// Remove forgets a remote server.
+func Remove(name string) error {
+	if err := config.Unset("remotes." + name); err != nil {
+		return err
 	}
-}
\ No newline at end of file
+	logger.Info(fmt.Sprintf("Removing configuration for remote %s", name))
+	return config.Save()
+}
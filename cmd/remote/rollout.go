@@ -0,0 +1,398 @@
+package remote
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"golang.org/x/crypto/ssh"
+	"stackroost-cli/cmd/internal/logger"
+)
+
+// RolloutOptions controls a rolling run of a stackroost command across a
+// group of remotes.
+type RolloutOptions struct {
+	// BatchSize is how many remotes are updated at a time.
+	BatchSize int
+	// HealthCheck is fetched from each remote once its web servers have
+	// reloaded; see parseHealthCheck. Empty skips the probe.
+	HealthCheck string
+	// Domain is the Host of health checks given as a bare path, usually the
+	// domain the command changes.
+	Domain string
+	// Rollback restores the web server configuration of every remote the
+	// rollout touched when a batch fails.
+	Rollback bool
+	// DryRun previews the command batch by batch; nothing is checked,
+	// reloaded or snapshotted.
+	DryRun bool
+}
+
+// healthAttempts and healthInterval give a reloaded server time to settle
+// before its health check counts as failed.
+const (
+	healthAttempts = 5
+	healthInterval = 2 * time.Second
+	healthTimeout  = 5 * time.Second
+)
+
+// serverPaths are the web server directories a rollback restores, relative
+// to /. The remote's stackroost config, which records the sites, is added
+// per remote.
+const serverPaths = "etc/nginx etc/apache2 etc/httpd etc/caddy"
+
+// configPathPrefix starts the line remote config-path prints.
+const configPathPrefix = "Config file: "
+
+// asRoot runs the rest of a script's commands through sudo unless the remote
+// user is root.
+const asRoot = `s=; [ "$(id -u)" = 0 ] || s="sudo -n"; cd /;`
+
+// RolloutError is returned when a rollout halted on a failed batch.
+type RolloutError struct {
+	Batch, Failed, Total int
+	RolledBack           bool
+}
+
+func (e *RolloutError) Error() string {
+	msg := fmt.Sprintf("rollout halted: batch %d failed on %d of %d remotes", e.Batch, e.Failed, e.Total)
+	if e.RolledBack {
+		msg += "; updated remotes were rolled back"
+	}
+	return msg
+}
+
+// ExitCode makes stackroost exit with status 1.
+func (e *RolloutError) ExitCode() int { return 1 }
+
+// rolloutHost tracks one remote through a rollout.
+type rolloutHost struct {
+	name     string
+	batch    int
+	status   string
+	duration time.Duration
+	err      error
+
+	// archive holds the remote's configuration for a rollback, and list the
+	// paths that existed when it was taken; both are shell-quoted. config is
+	// the remote's stackroost config, relative to /.
+	archive     string
+	list        string
+	config      string
+	snapshotted bool
+}
+
+// Rollout runs stackroost with args on the remotes selected by target, a
+// remote name or a tag selector, opts.BatchSize at a time. After the command
+// each remote checks and reloads its web servers and is probed over HTTP; if
+// any remote of a batch fails, the rollout stops there and, with
+// opts.Rollback, restores the configuration of every remote it touched.
+func Rollout(target string, args []string, opts RolloutOptions) error {
+	names := []string{target}
+	if _, err := Lookup(target); err != nil {
+		if names, err = Select(target); err != nil {
+			return fmt.Errorf("%s is neither a remote nor a tag of any remote", target)
+		}
+	}
+	var check *healthCheck
+	if opts.HealthCheck != "" && !opts.DryRun {
+		var err error
+		if check, err = parseHealthCheck(opts.HealthCheck, opts.Domain); err != nil {
+			return err
+		}
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1
+	}
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Errorf("could not name the rollout snapshots: %w", err)
+	}
+	id := hex.EncodeToString(buf)
+	command := stackroostCommand(args)
+
+	hosts := make([]*rolloutHost, len(names))
+	for i, name := range names {
+		base := fmt.Sprintf("/var/tmp/stackroost-rollout-%s-%s", id, name)
+		hosts[i] = &rolloutHost{name: name, batch: i/opts.BatchSize + 1, status: "skipped",
+			archive: shellQuote(base + ".tgz"), list: shellQuote(base + ".paths")}
+	}
+	batches := (len(hosts) + opts.BatchSize - 1) / opts.BatchSize
+	var halted *RolloutError
+	for b := 1; b <= batches && halted == nil; b++ {
+		var batch []*rolloutHost
+		for _, h := range hosts {
+			if h.batch == b {
+				batch = append(batch, h)
+			}
+		}
+		logger.Info(fmt.Sprintf("Batch %d of %d: %s", b, batches, hostNames(batch)))
+		eachHost(batch, func(h *rolloutHost, stdout, stderr io.Writer) {
+			start := time.Now()
+			h.err = deploy(h, command, check, opts, stdout, stderr)
+			h.duration = time.Since(start)
+			switch {
+			case h.err != nil:
+				h.status = "failed"
+			case opts.DryRun:
+				h.status = "planned"
+			default:
+				h.status = "updated"
+			}
+		})
+		failed := 0
+		for _, h := range batch {
+			if h.err != nil {
+				failed++
+			}
+		}
+		if failed > 0 {
+			halted = &RolloutError{Batch: b, Failed: failed, Total: len(batch)}
+		}
+	}
+
+	var touched []*rolloutHost
+	for _, h := range hosts {
+		if h.snapshotted {
+			touched = append(touched, h)
+		}
+	}
+	if halted != nil && opts.Rollback && len(touched) > 0 {
+		logger.Info(fmt.Sprintf("Rolling back %s", hostNames(touched)))
+		eachHost(touched, func(h *rolloutHost, stdout, stderr io.Writer) {
+			if err := rollback(h, stdout, stderr); err != nil {
+				h.status, h.err = "rollback failed", err
+				return
+			}
+			h.status = "rolled back"
+		})
+		halted.RolledBack = true
+		for _, h := range touched {
+			if h.status != "rolled back" {
+				halted.RolledBack = false
+			}
+		}
+	}
+	for _, h := range touched {
+		if t, err := Lookup(h.name); err == nil {
+			if client, err := Connect(t); err == nil {
+				output(client, h.name, fmt.Sprintf(`%s $s rm -f %s %s`, asRoot, h.archive, h.list))
+			}
+		}
+	}
+
+	printRollout(os.Stdout, hosts)
+	if halted != nil {
+		return halted
+	}
+	return nil
+}
+
+// deploy takes one remote through the steps of a rollout.
+func deploy(h *rolloutHost, command string, check *healthCheck, opts RolloutOptions, stdout, stderr io.Writer) error {
+	t, err := Lookup(h.name)
+	if err != nil {
+		return err
+	}
+	client, err := Connect(t)
+	if err != nil {
+		return err
+	}
+	if opts.Rollback && !opts.DryRun {
+		if err := snapshot(client, h); err != nil {
+			return fmt.Errorf("could not snapshot the configuration: %w", err)
+		}
+	}
+	if _, err := run(t, command, stdout, stderr, ExecOptions{}); err != nil {
+		return notInstalled(err)
+	}
+	if opts.DryRun {
+		return nil
+	}
+	if _, err := run(t, "stackroost server test --reload", stdout, stderr, ExecOptions{}); err != nil {
+		return fmt.Errorf("web server check failed: %w", err)
+	}
+	if check == nil {
+		return nil
+	}
+	if err := check.probe(client); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Health check passed: %s\n", check.url)
+	return nil
+}
+
+// snapshot archives the web server directories and the stackroost config of
+// a remote, asking its stackroost which config file it uses.
+func snapshot(client *ssh.Client, h *rolloutHost) error {
+	out, err := output(client, h.name, "stackroost remote config-path")
+	if err != nil {
+		return fmt.Errorf("%w\nstackroost on %s may predate rollouts; update it with stackroost remote bootstrap %s", err, h.name, h.name)
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if path, ok := strings.CutPrefix(strings.TrimSpace(line), configPathPrefix); ok {
+			h.config = strings.TrimPrefix(path, "/")
+		}
+	}
+	if h.config == "" {
+		return fmt.Errorf("stackroost on %s did not report its config file", h.name)
+	}
+	script := fmt.Sprintf(`set -e; umask 077; %s for p in %s %s; do [ ! -e "$p" ] || printf '%%s\n' "$p"; done | $s tee %s >/dev/null; $s tar -czpf %s -T %s`,
+		asRoot, serverPaths, shellQuote(h.config), h.list, h.archive, h.list)
+	if _, err := output(client, h.name, script); err != nil {
+		return err
+	}
+	h.snapshotted = true
+	return nil
+}
+
+// rollback restores the snapshot taken on a remote and reloads its web
+// servers. Only the paths that existed when the snapshot was taken are
+// replaced, so a server directory created since is left alone; a config
+// file created since is removed.
+func rollback(h *rolloutHost, stdout, stderr io.Writer) error {
+	t, err := Lookup(h.name)
+	if err != nil {
+		return err
+	}
+	client, err := Connect(t)
+	if err != nil {
+		return err
+	}
+	config := shellQuote(h.config)
+	script := fmt.Sprintf(`set -e; %s $s tar -tzf %s >/dev/null; $s cat %s | while IFS= read -r p; do $s rm -rf -- "$p"; done; $s tar -xzpf %s; $s grep -qxF %s %s || $s rm -f -- %s`,
+		asRoot, h.archive, h.list, h.archive, config, h.list, config)
+	if _, err := output(client, h.name, script); err != nil {
+		return fmt.Errorf("could not restore the configuration: %w", err)
+	}
+	fmt.Fprintln(stdout, "Restored the configuration from before the rollout")
+	if _, err := run(t, "stackroost server test --reload", stdout, stderr, ExecOptions{}); err != nil {
+		return fmt.Errorf("web server check failed after restoring: %w", err)
+	}
+	return nil
+}
+
+// eachHost runs fn for every host in parallel, prefixing their output lines
+// with the remote's name as FanOut does.
+func eachHost(hosts []*rolloutHost, fn func(h *rolloutHost, stdout, stderr io.Writer)) {
+	var out sync.Mutex
+	var wg sync.WaitGroup
+	for _, h := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			prefix := color.New(color.FgCyan).Sprintf("[%s] ", h.name)
+			stdout := &prefixWriter{mu: &out, w: os.Stdout, prefix: prefix}
+			stderr := &prefixWriter{mu: &out, w: os.Stderr, prefix: prefix}
+			fn(h, stdout, stderr)
+			stdout.Flush()
+			stderr.Flush()
+		}()
+	}
+	wg.Wait()
+}
+
+func hostNames(hosts []*rolloutHost) string {
+	names := make([]string, len(hosts))
+	for i, h := range hosts {
+		names[i] = h.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// printRollout writes the summary of a rollout.
+func printRollout(w io.Writer, hosts []*rolloutHost) {
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REMOTE\tBATCH\tSTATUS\tDURATION\tERROR")
+	for _, h := range hosts {
+		duration, reason := "-", ""
+		if h.duration > 0 {
+			duration = h.duration.Round(time.Millisecond).String()
+		}
+		if h.err != nil {
+			reason = h.err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", h.name, h.batch, h.status, duration, reason)
+	}
+	tw.Flush()
+}
+
+// healthCheck is an HTTP request made to a remote's own web server. The
+// connection is opened from the remote through SSH, so hosts behind jump
+// hosts or firewalls can be probed, and a load balancer in front of the
+// pool cannot answer in their place.
+type healthCheck struct {
+	url  *url.URL
+	addr string
+}
+
+// parseHealthCheck accepts a URL, whose host is sent as the Host header and
+// TLS server name, or a bare path, fetched over plain HTTP for domain (or
+// localhost). Either way the request goes to port 80, 443 or the URL's port
+// on the remote's loopback address.
+func parseHealthCheck(check, domain string) (*healthCheck, error) {
+	if strings.HasPrefix(check, "/") {
+		if domain == "" {
+			domain = "localhost"
+		}
+		check = "http://" + domain + check
+	}
+	u, err := url.Parse(check)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid health check %q, expected a path or an http(s) URL", check)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return &healthCheck{url: u, addr: net.JoinHostPort("127.0.0.1", port)}, nil
+}
+
+// probe fetches the health check URL through client until it answers with a
+// status below 400 or healthAttempts requests have failed.
+func (c *healthCheck) probe(client *ssh.Client) error {
+	httpClient := &http.Client{
+		Timeout: healthTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return client.DialContext(ctx, "tcp", c.addr)
+			},
+			DisableKeepAlives: true,
+		},
+		// A redirect, say to HTTPS, shows the server is answering.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	var err error
+	for attempt := 1; attempt <= healthAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(healthInterval)
+		}
+		var resp *http.Response
+		if resp, err = httpClient.Get(c.url.String()); err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 400 {
+			return nil
+		}
+		err = fmt.Errorf("%s returned %s", c.url, resp.Status)
+	}
+	return fmt.Errorf("health check failed: %w", err)
+}
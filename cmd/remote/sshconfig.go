@@ -0,0 +1,251 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// sshConfigFile is the user's OpenSSH client configuration.
+func sshConfigFile() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ssh", "config")
+}
+
+// sshConfig is a parsed OpenSSH client configuration: Host blocks in file
+// order, with Include directives already expanded in place.
+type sshConfig struct {
+	blocks []sshHostBlock
+}
+
+type sshHostBlock struct {
+	patterns []string
+	// scope holds the patterns of the Host blocks enclosing the Include the
+	// block was read from, all of which must match as well.
+	scope  [][]string
+	params [][2]string // lower-cased keyword and value, in file order
+}
+
+// loadSSHConfig parses file. A missing file is an empty configuration.
+func loadSSHConfig(file string) (*sshConfig, error) {
+	c := &sshConfig{blocks: []sshHostBlock{{patterns: []string{"*"}}}}
+	if err := c.parse(file, nil, 0); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parse appends the blocks in file, each limited to scope. Options before the
+// first Host line belong to the block holding the Include, so they start a
+// catch-all block in that scope.
+func (c *sshConfig) parse(file string, scope [][]string, depth int) error {
+	if depth > 16 {
+		return fmt.Errorf("%s: too many nested Include directives", file)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if depth > 0 {
+		c.blocks = append(c.blocks, sshHostBlock{patterns: []string{"*"}, scope: scope})
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keyword, value, ok := splitSSHConfigLine(line)
+		if !ok {
+			return fmt.Errorf("%s:%d: missing value for %s", file, n, line)
+		}
+		switch keyword {
+		case "host":
+			c.blocks = append(c.blocks, sshHostBlock{patterns: strings.Fields(value), scope: scope})
+		case "match":
+			// Match conditions are not evaluated; their options are skipped.
+			c.blocks = append(c.blocks, sshHostBlock{scope: scope})
+		case "include":
+			// Like ssh, an Include inside a Host block only applies to the
+			// hosts that block matches.
+			outer := c.blocks[len(c.blocks)-1]
+			inner := append(slices.Clip(outer.scope), outer.patterns)
+			for _, pattern := range strings.Fields(value) {
+				pattern = expandHome(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(filepath.Dir(sshConfigFile()), pattern)
+				}
+				matches, err := filepath.Glob(pattern)
+				if err != nil {
+					return fmt.Errorf("%s:%d: %w", file, n, err)
+				}
+				for _, match := range matches {
+					if err := c.parse(match, inner, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+			// Options after the Include continue the enclosing block.
+			c.blocks = append(c.blocks, sshHostBlock{patterns: outer.patterns, scope: outer.scope})
+		default:
+			last := &c.blocks[len(c.blocks)-1]
+			last.params = append(last.params, [2]string{keyword, strings.Trim(value, `"`)})
+		}
+	}
+	return scanner.Err()
+}
+
+// splitSSHConfigLine splits "Keyword value" or "Keyword=value".
+func splitSSHConfigLine(line string) (keyword, value string, ok bool) {
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return "", "", false
+	}
+	keyword = strings.ToLower(line[:i])
+	value = strings.TrimLeft(line[i:], " \t")
+	value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+	return keyword, value, value != ""
+}
+
+// matches reports whether alias matches the block's patterns and every
+// pattern list in its scope.
+func (b sshHostBlock) matches(alias string) bool {
+	for _, patterns := range b.scope {
+		if !matchPatterns(patterns, alias) {
+			return false
+		}
+	}
+	return matchPatterns(b.patterns, alias)
+}
+
+// matchPatterns reports whether at least one pattern matches alias and no
+// negated pattern does.
+func matchPatterns(patterns []string, alias string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		if ok, _ := path.Match(strings.TrimPrefix(pattern, "!"), alias); ok {
+			if negated {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// get returns the first value of keyword that applies to alias, as OpenSSH
+// does.
+func (c *sshConfig) get(alias, keyword string) string {
+	for _, b := range c.blocks {
+		if !b.matches(alias) {
+			continue
+		}
+		for _, p := range b.params {
+			if p[0] == keyword {
+				return p[1]
+			}
+		}
+	}
+	return ""
+}
+
+// hosts lists the aliases named explicitly in Host lines, skipping wildcard
+// and negated patterns.
+func (c *sshConfig) hosts() []string {
+	var aliases []string
+	seen := map[string]bool{}
+	for _, b := range c.blocks {
+		for _, pattern := range b.patterns {
+			if strings.ContainsAny(pattern, "*?!") || seen[pattern] || !b.matches(pattern) {
+				continue
+			}
+			seen[pattern] = true
+			aliases = append(aliases, pattern)
+		}
+	}
+	return aliases
+}
+
+// defines reports whether alias is covered by a Host block other than the
+// catch-all "Host *".
+func (c *sshConfig) defines(alias string) bool {
+	for _, b := range c.blocks[1:] {
+		if len(b.patterns) == 1 && b.patterns[0] == "*" {
+			continue
+		}
+		if b.matches(alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// target resolves alias the way ssh would.
+func (c *sshConfig) target(alias string) (Target, error) {
+	t := Target{Name: alias, Host: alias, Port: 22}
+	if hostname := c.get(alias, "hostname"); hostname != "" {
+		t.Host = strings.ReplaceAll(hostname, "%h", alias)
+	}
+	if port := c.get(alias, "port"); port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil {
+			return Target{}, fmt.Errorf("ssh config: invalid Port %q for %s", port, alias)
+		}
+		t.Port = n
+	}
+	t.User = c.get(alias, "user")
+	if t.User == "" {
+		if u, err := user.Current(); err == nil {
+			t.User = u.Username
+		}
+	}
+	if identity := c.get(alias, "identityfile"); identity != "" && !strings.EqualFold(identity, "none") {
+		t.Key = expandSSHTokens(identity, t)
+	} else {
+		t.Key = defaultIdentity()
+	}
+	if jump := c.get(alias, "proxyjump"); jump != "" && !strings.EqualFold(jump, "none") {
+		t.Jump = strings.Split(jump, ",")
+	}
+	return t, nil
+}
+
+// expandSSHTokens expands ~ and the %d, %h, %r and %u tokens in a path.
+func expandSSHTokens(s string, t Target) string {
+	home, _ := os.UserHomeDir()
+	local := ""
+	if u, err := user.Current(); err == nil {
+		local = u.Username
+	}
+	s = strings.NewReplacer("%d", home, "%h", t.Host, "%r", t.User, "%u", local, "%%", "%").Replace(s)
+	return expandHome(s)
+}
+
+func expandHome(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, p[1:])
+	}
+	return p
+}
+
+// defaultIdentity returns the first of the keys ssh tries by default that
+// exists.
+func defaultIdentity() string {
+	home, _ := os.UserHomeDir()
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		file := filepath.Join(home, ".ssh", name)
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+	}
+	return ""
+}
@@ -0,0 +1,49 @@
+package remote
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// attachTerminal requests a PTY for session sized like the local terminal,
+// connects it to stdin and puts the local terminal into raw mode so every
+// keystroke, Ctrl-C included, goes to the remote. The returned function
+// restores the terminal.
+func attachTerminal(session *ssh.Session) (func(), error) {
+	fd := int(os.Stdin.Fd())
+	width, height := 80, 24
+	interactive := term.IsTerminal(fd)
+	if interactive {
+		if w, h, err := term.GetSize(fd); err == nil {
+			width, height = w, h
+		}
+	}
+	termType := os.Getenv("TERM")
+	if termType == "" {
+		termType = "xterm-256color"
+	}
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty(termType, height, width, modes); err != nil {
+		return nil, fmt.Errorf("failed to allocate a terminal: %w", err)
+	}
+	session.Stdin = os.Stdin
+	if !interactive {
+		return func() {}, nil
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	stopResize := watchResize(fd, session)
+	return func() {
+		stopResize()
+		term.Restore(fd, state)
+	}, nil
+}
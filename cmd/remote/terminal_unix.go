@@ -0,0 +1,30 @@
+//go:build !windows
+
+package remote
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// watchResize passes local terminal size changes on to the remote PTY until
+// the returned function is called.
+func watchResize(fd int, session *ssh.Session) func() {
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	go func() {
+		for range winch {
+			if w, h, err := term.GetSize(fd); err == nil {
+				session.WindowChange(h, w)
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(winch)
+		close(winch)
+	}
+}
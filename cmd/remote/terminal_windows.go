@@ -0,0 +1,8 @@
+package remote
+
+import "golang.org/x/crypto/ssh"
+
+// watchResize is a no-op: Windows consoles do not signal size changes.
+func watchResize(fd int, session *ssh.Session) func() {
+	return func() {}
+}
@@ -0,0 +1,372 @@
+package remote
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"stackroost-cli/cmd/internal/system"
+)
+
+// TransferOptions controls push and pull.
+type TransferOptions struct {
+	// Sudo stages files in a temporary directory on the remote and moves them
+	// with sudo, for destinations the remote user cannot write (push) or
+	// sources it cannot read (pull). It needs passwordless sudo.
+	Sudo bool
+}
+
+// transfer copies files between the local machine and one remote over SFTP.
+type transfer struct {
+	target  Target
+	ssh     *ssh.Client
+	sftp    *sftp.Client
+	opts    TransferOptions
+	copied  int
+	skipped int
+}
+
+func newTransfer(t Target, opts TransferOptions) (*transfer, error) {
+	client, err := Connect(t)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := sftp.NewClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start SFTP on %s: %w", t.Name, err)
+	}
+	return &transfer{target: t, ssh: client, sftp: sc, opts: opts}, nil
+}
+
+func (tr *transfer) Close() {
+	tr.sftp.Close()
+}
+
+// Push copies a local file or directory tree to remotePath on t. As with
+// scp, an existing remote directory receives the source under its own name.
+// Permissions and modification times are preserved, and files whose
+// checksum already matches are skipped.
+func Push(t Target, local, remotePath string, opts TransferOptions) error {
+	tr, err := newTransfer(t, opts)
+	if err != nil {
+		return err
+	}
+	defer tr.Close()
+	if _, err := os.Stat(local); err != nil {
+		return err
+	}
+	dest := remotePath
+	if st, err := tr.stat(remotePath); err == nil && st.IsDir() {
+		dest = path.Join(remotePath, filepath.Base(filepath.Clean(local)))
+	}
+
+	// Without sudo files go straight to dest; with it they are uploaded
+	// into a staging directory that is copied over dest at the end.
+	root := dest
+	var stage string
+	dry := dryRun()
+	if opts.Sudo && !dry {
+		if stage, err = tr.tempDir(); err != nil {
+			return err
+		}
+		defer tr.run(fmt.Sprintf("rm -rf %s", shellQuote(stage)))
+		root = path.Join(stage, "root")
+	}
+
+	err = filepath.WalkDir(local, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(local, file)
+		rel = filepath.ToSlash(rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		to := path.Join(root, rel)
+		if d.IsDir() {
+			if dry {
+				return nil
+			}
+			if err := tr.sftp.MkdirAll(to); err != nil {
+				return fmt.Errorf("%s: %w", tr.remote(to), err)
+			}
+			return tr.sftp.Chmod(to, info.Mode().Perm())
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		final := path.Join(dest, rel)
+		if tr.unchanged(file, final, info.Size()) {
+			tr.skipped++
+			return nil
+		}
+		if system.Simulated("upload", fmt.Sprintf("%s -> %s", file, tr.remote(final))) {
+			return nil
+		}
+		return tr.upload(file, to, info)
+	})
+	if err != nil {
+		return err
+	}
+	if opts.Sudo && tr.copied > 0 {
+		// cp -T merges the staged tree into dest; files are owned by root
+		// while mode and mtime are kept.
+		install := fmt.Sprintf("sudo -n mkdir -p %s && sudo -n cp -rT --preserve=mode,timestamps %s %s",
+			shellQuote(path.Dir(dest)), shellQuote(root), shellQuote(dest))
+		if err := tr.run(install); err != nil {
+			return err
+		}
+	}
+	tr.summary()
+	return nil
+}
+
+// Pull copies a remote file or directory tree to local, the mirror image of
+// Push.
+func Pull(t Target, remotePath, local string, opts TransferOptions) error {
+	tr, err := newTransfer(t, opts)
+	if err != nil {
+		return err
+	}
+	defer tr.Close()
+	source := remotePath
+	// A dry run reads remotePath directly, as far as the user can.
+	if opts.Sudo && !system.Simulated("stage", fmt.Sprintf("sudo copy of %s for download", tr.remote(remotePath))) {
+		stage, err := tr.tempDir()
+		if err != nil {
+			return err
+		}
+		defer tr.run(fmt.Sprintf("rm -rf %s", shellQuote(stage)))
+		source = path.Join(stage, "root")
+		copyOut := fmt.Sprintf("sudo -n cp -r --preserve=mode,timestamps %s %s && sudo -n chown -R \"$(id -u):$(id -g)\" %s",
+			shellQuote(remotePath), shellQuote(source), shellQuote(source))
+		if err := tr.run(copyOut); err != nil {
+			return err
+		}
+	}
+	if _, err := tr.sftp.Stat(source); err != nil {
+		return fmt.Errorf("%s: %w", tr.remote(remotePath), err)
+	}
+	dest := local
+	if st, err := os.Stat(local); err == nil && st.IsDir() {
+		dest = filepath.Join(local, path.Base(path.Clean(remotePath)))
+	}
+
+	walker := tr.sftp.Walk(source)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), source), "/")
+		info := walker.Stat()
+		to := filepath.Join(dest, filepath.FromSlash(rel))
+		if info.IsDir() {
+			if err := system.MkdirAll(to, info.Mode().Perm()); err != nil {
+				return err
+			}
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if local, err := os.Stat(to); err == nil && local.Size() == info.Size() {
+			if sum, err := localChecksum(to); err == nil && sum == tr.checksum(walker.Path()) {
+				tr.skipped++
+				continue
+			}
+		}
+		if system.Simulated("download", fmt.Sprintf("%s -> %s", tr.remote(path.Join(remotePath, rel)), to)) {
+			continue
+		}
+		if err := tr.download(walker.Path(), to, info); err != nil {
+			return err
+		}
+	}
+	tr.summary()
+	return nil
+}
+
+// upload writes one local file to the remote path to.
+func (tr *transfer) upload(from, to string, info fs.FileInfo) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := tr.sftp.MkdirAll(path.Dir(to)); err != nil {
+		return fmt.Errorf("%s: %w", tr.remote(path.Dir(to)), err)
+	}
+	dst, err := tr.sftp.Create(to)
+	if err != nil {
+		return fmt.Errorf("%s: %w", tr.remote(to), err)
+	}
+	p := newProgress(from, info.Size())
+	_, err = io.Copy(dst, io.TeeReader(src, p))
+	p.done()
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("uploading %s: %w", from, err)
+	}
+	if err := tr.sftp.Chmod(to, info.Mode().Perm()); err != nil {
+		return err
+	}
+	tr.copied++
+	return tr.sftp.Chtimes(to, info.ModTime(), info.ModTime())
+}
+
+// download writes one remote file to the local path to, replacing it
+// atomically.
+func (tr *transfer) download(from, to string, info fs.FileInfo) error {
+	src, err := tr.sftp.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(to), "."+filepath.Base(to)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	p := newProgress(to, info.Size())
+	_, err = io.Copy(tmp, io.TeeReader(src, p))
+	p.done()
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("downloading %s: %w", from, err)
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	tr.copied++
+	return os.Rename(tmp.Name(), to)
+}
+
+// unchanged reports whether the remote file already has the contents of the
+// local one.
+func (tr *transfer) unchanged(local, remote string, size int64) bool {
+	st, err := tr.stat(remote)
+	switch {
+	case err == nil && st.Size() != size:
+		return false
+	case err != nil && !tr.opts.Sudo:
+		// Missing. Under sudo it may just be out of the user's reach, which
+		// the checksum settles.
+		return false
+	}
+	sum, err := localChecksum(local)
+	return err == nil && sum == tr.checksum(remote)
+}
+
+func (tr *transfer) stat(remote string) (fs.FileInfo, error) {
+	return tr.sftp.Stat(remote)
+}
+
+// checksum returns the SHA-256 of a remote file, or "" if it cannot be
+// computed.
+func (tr *transfer) checksum(remote string) string {
+	out, err := tr.output(fmt.Sprintf("%ssha256sum -- %s", tr.sudo(), shellQuote(remote)))
+	if err != nil {
+		return ""
+	}
+	sum, _, _ := strings.Cut(out, " ")
+	return sum
+}
+
+func localChecksum(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// dryRun reports whether transfers must only be recorded.
+func dryRun() bool {
+	_, ok := system.Current().(*system.DryRun)
+	return ok
+}
+
+func (tr *transfer) sudo() string {
+	if tr.opts.Sudo {
+		return "sudo -n "
+	}
+	return ""
+}
+
+// tempDir creates a private staging directory on the remote.
+func (tr *transfer) tempDir() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("could not name a staging directory: %w", err)
+	}
+	dir := "/tmp/.stackroost-" + hex.EncodeToString(buf)
+	if err := tr.sftp.Mkdir(dir); err != nil {
+		return "", fmt.Errorf("%s: %w", tr.remote(dir), err)
+	}
+	return dir, tr.sftp.Chmod(dir, 0700)
+}
+
+// run executes a helper command on the remote, failing with its stderr.
+func (tr *transfer) run(command string) error {
+	_, err := tr.output(command)
+	return err
+}
+
+func (tr *transfer) output(command string) (string, error) {
+	return output(tr.ssh, tr.target.Name, command)
+}
+
+// output runs a helper command on client and returns its stdout, failing
+// with its stderr.
+func output(client *ssh.Client, name, command string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	var stderr strings.Builder
+	session.Stderr = &stderr
+	out, err := session.Output(command)
+	if err != nil {
+		return "", fmt.Errorf("%s on %s: %w\n%s", command, name, err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+func (tr *transfer) remote(p string) string {
+	return tr.target.Name + ":" + p
+}
+
+func (tr *transfer) summary() {
+	fmt.Printf("%d copied, %d unchanged\n", tr.copied, tr.skipped)
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
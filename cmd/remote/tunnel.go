@@ -0,0 +1,303 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"stackroost-cli/cmd/internal/logger"
+)
+
+// Forward is one port forwarded through a tunnel.
+type Forward struct {
+	// Reverse forwards listen on the remote and connect from the local
+	// machine, like ssh -R; otherwise it is the other way round, like -L.
+	Reverse  bool
+	Bind     string
+	Port     int
+	Host     string
+	HostPort int
+}
+
+// ParseForward parses [bind_address:]port:host:hostport as ssh -L and -R do.
+// A lone port forwards to the same port on localhost. IPv6 addresses are
+// written in brackets.
+func ParseForward(spec string, reverse bool) (Forward, error) {
+	parts := splitForward(spec)
+	f := Forward{Reverse: reverse, Bind: "localhost", Host: "localhost"}
+	var port, hostPort string
+	switch len(parts) {
+	case 1:
+		port, hostPort = parts[0], parts[0]
+	case 3:
+		port, f.Host, hostPort = parts[0], parts[1], parts[2]
+	case 4:
+		f.Bind, port, f.Host, hostPort = parts[0], parts[1], parts[2], parts[3]
+	default:
+		return Forward{}, fmt.Errorf("invalid forward %q, expected [bind_address:]port:host:hostport", spec)
+	}
+	if f.Bind == "*" || f.Bind == "" {
+		f.Bind = "0.0.0.0"
+	}
+	var err error
+	if f.Port, err = strconv.Atoi(port); err != nil || f.Port < 0 || f.Port > 65535 {
+		return Forward{}, fmt.Errorf("invalid port %q in forward %q", port, spec)
+	}
+	if f.HostPort, err = strconv.Atoi(hostPort); err != nil || f.HostPort <= 0 || f.HostPort > 65535 {
+		return Forward{}, fmt.Errorf("invalid port %q in forward %q", hostPort, spec)
+	}
+	return f, nil
+}
+
+// splitForward splits on colons outside brackets and drops the brackets.
+func splitForward(spec string) []string {
+	var parts []string
+	var part strings.Builder
+	bracket := false
+	for _, r := range spec {
+		switch {
+		case r == '[':
+			bracket = true
+		case r == ']':
+			bracket = false
+		case r == ':' && !bracket:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteRune(r)
+		}
+	}
+	return append(parts, part.String())
+}
+
+func (f Forward) listenAddr() string {
+	return net.JoinHostPort(f.Bind, strconv.Itoa(f.Port))
+}
+
+func (f Forward) destAddr() string {
+	return net.JoinHostPort(f.Host, strconv.Itoa(f.HostPort))
+}
+
+// keepAliveInterval is how often an idle tunnel checks that the remote still
+// answers; a tunnel that misses one reply reconnects.
+const keepAliveInterval = 30 * time.Second
+
+// maxReconnectDelay caps the wait between attempts to reconnect a tunnel.
+const maxReconnectDelay = 30 * time.Second
+
+// tunnel keeps forwards open to one remote, reconnecting when the
+// connection drops.
+type tunnel struct {
+	target   Target
+	forwards []Forward
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// Tunnel forwards ports to and from t until interrupted with Ctrl-C. Local
+// ports stay bound while the connection is re-established after a drop;
+// remote ports are bound again on every connection.
+func Tunnel(t Target, forwards []Forward) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	tn := &tunnel{target: t, forwards: forwards}
+	for _, f := range forwards {
+		if f.Reverse {
+			continue
+		}
+		l, err := net.Listen("tcp", f.listenAddr())
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		fmt.Printf("Forwarding %s -> %s on %s\n", l.Addr(), f.destAddr(), t.Name)
+		go tn.serveLocal(l, f)
+	}
+	client, err := tn.connect()
+	if err != nil {
+		return err
+	}
+	logger.Success(fmt.Sprintf("Tunnel to %s is open; press Ctrl-C to close it", t.Name))
+
+	for {
+		lost := make(chan struct{})
+		go func() {
+			client.Wait()
+			close(lost)
+		}()
+		select {
+		case <-ctx.Done():
+			client.Close()
+			logger.Info(fmt.Sprintf("Closed tunnel to %s", t.Name))
+			return nil
+		case <-lost:
+		}
+		tn.setClient(nil)
+		if client, err = tn.reconnect(ctx); err != nil {
+			logger.Info(fmt.Sprintf("Closed tunnel to %s", t.Name))
+			return nil
+		}
+	}
+}
+
+// reconnect retries connecting, waiting longer after each failure, until it
+// succeeds or ctx is cancelled.
+func (tn *tunnel) reconnect(ctx context.Context) (*ssh.Client, error) {
+	logger.Error(fmt.Sprintf("Connection to %s lost", tn.target.Name))
+	delay := time.Second
+	for {
+		logger.Info(fmt.Sprintf("Reconnecting to %s in %s", tn.target.Name, delay))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		client, err := tn.connect()
+		if err == nil {
+			logger.Success(fmt.Sprintf("Reconnected to %s", tn.target.Name))
+			return client, nil
+		}
+		logger.Error(err.Error())
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// connect dials the remote with a connection of the tunnel's own, since
+// remote forwards cannot be shared, and sets up the remote forwards on it.
+func (tn *tunnel) connect() (*ssh.Client, error) {
+	client, err := Dial(tn.target)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range tn.forwards {
+		if !f.Reverse {
+			continue
+		}
+		l, err := client.Listen("tcp", f.listenAddr())
+		if err != nil {
+			client.Close()
+			return nil, fmt.Errorf("%s refused to listen on %s: %w", tn.target.Name, f.listenAddr(), err)
+		}
+		// The port is the one the remote picked when asked for port 0.
+		bound := net.JoinHostPort(f.Bind, strconv.Itoa(l.Addr().(*net.TCPAddr).Port))
+		fmt.Printf("Forwarding %s on %s -> %s\n", bound, tn.target.Name, f.destAddr())
+		go tn.serveRemote(l, f)
+	}
+	go keepAlive(client)
+	tn.setClient(client)
+	return client, nil
+}
+
+func (tn *tunnel) setClient(client *ssh.Client) {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	tn.client = client
+}
+
+func (tn *tunnel) currentClient() *ssh.Client {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	return tn.client
+}
+
+// serveLocal forwards connections accepted locally to the remote's side.
+func (tn *tunnel) serveLocal(l net.Listener, f Forward) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			client := tn.currentClient()
+			if client == nil {
+				conn.Close()
+				return
+			}
+			remote, err := client.Dial("tcp", f.destAddr())
+			if err != nil {
+				logger.Error(fmt.Sprintf("%s could not connect to %s: %v", tn.target.Name, f.destAddr(), err))
+				conn.Close()
+				return
+			}
+			join(conn, remote)
+		}()
+	}
+}
+
+// serveRemote forwards connections the remote accepted to the local side.
+// It stops when the connection it was set up on closes.
+func (tn *tunnel) serveRemote(l net.Listener, f Forward) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			local, err := net.DialTimeout("tcp", f.destAddr(), connectTimeout)
+			if err != nil {
+				logger.Error(fmt.Sprintf("could not connect to %s: %v", f.destAddr(), err))
+				conn.Close()
+				return
+			}
+			join(conn, local)
+		}()
+	}
+}
+
+// keepAlive closes client when the remote stops answering, which turns a
+// silently dead network path into a reconnect.
+func keepAlive(client *ssh.Client) {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		replied := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			replied <- err
+		}()
+		select {
+		case err := <-replied:
+			if err != nil {
+				// Already closed.
+				return
+			}
+		case <-time.After(keepAliveInterval):
+			client.Close()
+			return
+		}
+	}
+}
+
+// join copies between a and b until both directions are done, passing on
+// half-closes.
+func join(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	wg.Wait()
+	a.Close()
+	b.Close()
+}
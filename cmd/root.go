@@ -5,13 +5,18 @@ Copyright © 2025 Stackroost CLI
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"stackroost-cli/cmd/apply"
 	"stackroost-cli/cmd/domain"
 	"stackroost-cli/cmd/internal/logger"
+	"stackroost-cli/cmd/internal/system"
 	"stackroost-cli/cmd/logs"
 	"stackroost-cli/cmd/remote"
 	"stackroost-cli/cmd/security"
@@ -20,7 +25,15 @@ import (
 
 
 
+// Version is the release being run, set when building with
+// -ldflags "-X stackroost-cli/cmd.Version=v1.2.3".
+var Version = "dev"
+
 var cfgFile string
+var dryRun bool
+var onTarget string
+var rolling remote.RolloutOptions
+var rollingOn bool
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -28,26 +41,52 @@ var rootCmd = &cobra.Command{
 	Short: "Cross-server CLI manager for web servers, domains, SSL, and user management",
 	Long: `Stackroost is a powerful CLI tool for managing web servers (Apache, Nginx, Caddy),
 domains, SSL certificates, user access, and multi-server deployments across local and remote systems.`,
+	SilenceErrors: true,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// Arguments have been validated by now; errors from here on are
+		// failures of the command itself, not usage mistakes.
+		cmd.SilenceUsage = true
+		if dryRun {
+			system.Use(system.NewDryRun(system.Current()))
+		}
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	PrintBanner()
+	routeRemote(rootCmd)
 	err := rootCmd.Execute()
+	remote.CloseConnections()
+	if plan, ok := system.Current().(*system.DryRun); ok {
+		plan.PrintPlan(os.Stdout)
+	}
 	if err != nil {
+		logger.Error(err.Error())
+		var exitErr interface{ ExitCode() int }
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
 		os.Exit(1)
 	}
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
+	rootCmd.Version = Version
 
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.stackroost.yaml)")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "print the files and commands that would change without touching the system")
+	rootCmd.PersistentFlags().StringVar(&onTarget, "on", "", "run the command on a remote, or on every remote with this tag, instead of locally")
+	rootCmd.PersistentFlags().BoolVar(&rollingOn, "rolling", false, "with --on, update the remotes in batches, checking and reloading web servers after each")
+	rootCmd.PersistentFlags().IntVar(&rolling.BatchSize, "batch-size", 1, "number of remotes a --rolling run updates at a time")
+	rootCmd.PersistentFlags().StringVar(&rolling.HealthCheck, "health-check", "", "path or URL a --rolling run fetches from each remote's web server after reloading")
+	rootCmd.PersistentFlags().BoolVar(&rolling.Rollback, "rollback", false, "restore the updated remotes if a --rolling run fails")
 
 	domain.AddDomainCommands(rootCmd)
 	server.AddServerCmd(rootCmd)
@@ -55,6 +94,7 @@ func init() {
 	security.AddUserCmd(rootCmd)
 	remote.AddRemoteCmd(rootCmd)
 	logs.AddLogsCmd(rootCmd)
+	apply.AddApplyCmds(rootCmd)
 }
 
 func initConfig() {
@@ -74,4 +114,69 @@ func initConfig() {
 	}
 }
 
-
+// routeRemote makes every command run through the stackroost binary on the
+// --on target instead of locally when the flag is given. The remote's own
+// config applies there, so --config is not passed on.
+func routeRemote(cmd *cobra.Command) {
+	if run := cmd.RunE; run != nil {
+		cmd.RunE = func(c *cobra.Command, args []string) error {
+			if onTarget == "" {
+				for _, flag := range []string{"rolling", "batch-size", "health-check", "rollback"} {
+					if c.Flags().Changed(flag) {
+						return fmt.Errorf("--%s needs --on", flag)
+					}
+				}
+				return run(c, args)
+			}
+			if !rollingOn {
+				for _, flag := range []string{"batch-size", "health-check", "rollback"} {
+					if c.Flags().Changed(flag) {
+						return fmt.Errorf("--%s needs --rolling", flag)
+					}
+				}
+			}
+			for p := c; p != nil; p = p.Parent() {
+				switch p.Name() {
+				case "remote", "plan", "apply":
+					return fmt.Errorf("--on cannot be used with %s", c.CommandPath())
+				}
+			}
+			var local []string
+			c.Flags().Visit(func(f *pflag.Flag) {
+				if _, ok := f.Annotations[cobra.BashCompFilenameExt]; ok {
+					local = append(local, "--"+f.Name)
+				}
+			})
+			if len(local) > 0 {
+				// The arguments are passed on as they are, so paths would be
+				// read on the remote rather than here.
+				return fmt.Errorf("--on cannot pass on %s, which name local files; copy them over with stackroost remote push and give the remote paths there", strings.Join(local, ", "))
+			}
+			if dryRun {
+				// Only the remote run is a dry run; nothing local to plan.
+				system.Use(system.Local{})
+			}
+			remoteArgs := remote.StripFlag(remote.StripFlag(os.Args[1:], "on"), "config")
+			if !rollingOn {
+				return remote.RunOn(onTarget, remoteArgs)
+			}
+			for _, flag := range []string{"batch-size", "health-check"} {
+				remoteArgs = remote.StripFlag(remoteArgs, flag)
+			}
+			for _, flag := range []string{"rolling", "rollback"} {
+				remoteArgs = remote.StripSwitch(remoteArgs, flag)
+			}
+			opts := rolling
+			opts.DryRun = dryRun
+			if c.Parent() != nil && c.Parent().Name() == "domain" && len(args) > 0 {
+				// Health checks given as a path are made to the domain
+				// being changed.
+				opts.Domain = args[0]
+			}
+			return remote.Rollout(onTarget, remoteArgs, opts)
+		}
+	}
+	for _, child := range cmd.Commands() {
+		routeRemote(child)
+	}
+}
@@ -0,0 +1,251 @@
+/*
+Copyright © 2025 Stackroost CLI
+*/
+package security
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+	"stackroost-cli/cmd/internal/acme"
+	"stackroost-cli/cmd/internal/system"
+	"stackroost-cli/cmd/internal/webserver"
+)
+
+// certbotLiveDir is where certbot keeps the current certificate of each name.
+const certbotLiveDir = "/etc/letsencrypt/live/"
+
+// Challenge types accepted by --challenge.
+const (
+	challengeHTTP = "http"
+	challengeDNS  = "dns"
+)
+
+// Flag overrides for the ssl.* config settings.
+var backendFlag, directoryFlag string
+
+// sslOption returns the flag value when given, else the config setting.
+func sslOption(flag, key string) string {
+	if flag != "" {
+		return flag
+	}
+	return viper.GetString(key)
+}
+
+// IssueOptions tunes how a certificate is requested.
+type IssueOptions struct {
+	Email string
+	// Names are extra subject alternative names on top of the domain and its
+	// aliases. Wildcards such as *.example.com require the dns challenge.
+	Names []string
+	// Challenge is "http" (default) or "dns".
+	Challenge string
+	// DNSProvider names the provider for the dns challenge, default
+	// ssl.dns.provider from the config.
+	DNSProvider string
+}
+
+// names returns every name the certificate for site must cover, primary
+// domain first and without duplicates.
+func (o IssueOptions) names(site webserver.Site) []string {
+	names := []string{site.Domain}
+	for _, name := range append(site.Aliases, o.Names...) {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (o IssueOptions) validate(site webserver.Site) (IssueOptions, error) {
+	if o.Challenge == "" {
+		o.Challenge = challengeHTTP
+	}
+	if o.Challenge != challengeHTTP && o.Challenge != challengeDNS {
+		return o, fmt.Errorf("unknown challenge %q (supported: http, dns)", o.Challenge)
+	}
+	for _, name := range o.names(site) {
+		if strings.HasPrefix(name, "*.") && o.Challenge != challengeDNS {
+			return o, fmt.Errorf("wildcard name %s requires --challenge dns", name)
+		}
+	}
+	if o.Challenge == challengeDNS && o.DNSProvider == "" {
+		o.DNSProvider = viper.GetString("ssl.dns.provider")
+		if o.DNSProvider == "" {
+			return o, fmt.Errorf("the dns challenge needs --dns-provider or ssl.dns.provider in the config")
+		}
+	}
+	return o, nil
+}
+
+// acmeBackend obtains certificates from an ACME CA such as Let's Encrypt.
+type acmeBackend interface {
+	// issue obtains a certificate for site and returns the installed
+	// certificate and key files.
+	issue(site webserver.Site, opts IssueOptions) (certFile, keyFile string, err error)
+	renew(site webserver.Site) error
+	revoke(site webserver.Site) error
+}
+
+// backendNamed returns the backend selected by --backend or ssl.backend.
+func backendNamed(name string) (acmeBackend, error) {
+	switch name {
+	case "", "lego":
+		return legoBackend{}, nil
+	case "certbot":
+		return certbotBackend{}, nil
+	}
+	return nil, fmt.Errorf("unknown ACME backend %q (supported: lego, certbot)", name)
+}
+
+// backendFor returns the backend that issued the certificate currently wired
+// into site.
+func backendFor(site webserver.Site) (acmeBackend, error) {
+	switch {
+	case site.CertFile == "":
+		return nil, fmt.Errorf("domain %s has no certificate", site.Domain)
+	case strings.HasPrefix(site.CertFile, certbotLiveDir):
+		return certbotBackend{}, nil
+	case strings.HasPrefix(site.CertFile, acme.CertDir):
+		return legoBackend{}, nil
+	}
+	return nil, fmt.Errorf("certificate %s for %s was not issued by stackroost", site.CertFile, site.Domain)
+}
+
+// legoBackend talks to the CA in-process and installs certificates under
+// acme.CertDir.
+type legoBackend struct{}
+
+func (legoBackend) client(email string) (*acme.Client, error) {
+	return acme.NewClient(acme.Options{
+		Directory: sslOption(directoryFlag, "ssl.directory"),
+		Email:     email,
+		StateDir:  viper.GetString("ssl.state_dir"),
+	})
+}
+
+// solve configures how client answers challenges for site.
+func (legoBackend) solve(client *acme.Client, site webserver.Site, challenge, provider string) error {
+	if challenge != challengeDNS {
+		return client.UseWebroot(site.Webroot())
+	}
+	dns, err := acme.NewDNSProvider(provider, viper.GetStringMapString("ssl.dns."+provider))
+	if err != nil {
+		return err
+	}
+	return client.UseDNS(dns, viper.GetStringSlice("ssl.dns.resolvers"))
+}
+
+func (b legoBackend) issue(site webserver.Site, opts IssueOptions) (string, string, error) {
+	certFile, keyFile := acme.Paths(site.Domain)
+	how := "HTTP-01 via " + site.Webroot()
+	if opts.Challenge == challengeDNS {
+		how = "DNS-01 via " + opts.DNSProvider
+	}
+	if system.Simulated("acme", fmt.Sprintf("order certificate for %s (%s)", strings.Join(opts.names(site), ", "), how)) {
+		return certFile, keyFile, nil
+	}
+	client, err := b.client(opts.Email)
+	if err != nil {
+		return "", "", err
+	}
+	if err := b.solve(client, site, opts.Challenge, opts.DNSProvider); err != nil {
+		return "", "", err
+	}
+	cert, err := client.Obtain(opts.names(site))
+	if err != nil {
+		return "", "", err
+	}
+	cert.Challenge, cert.DNSProvider = opts.Challenge+"-01", opts.DNSProvider
+	return certFile, keyFile, acme.Save(cert)
+}
+
+func (b legoBackend) renew(site webserver.Site) error {
+	if system.Simulated("acme", "renew certificate for "+site.Domain) {
+		return nil
+	}
+	current, err := acme.Load(site.Domain)
+	if err != nil {
+		return err
+	}
+	if current.Challenge == acme.Manual {
+		return fmt.Errorf("certificate for %s was uploaded manually; upload a new one instead", site.Domain)
+	}
+	client, err := b.client(current.Email)
+	if err != nil {
+		return err
+	}
+	challenge := strings.TrimSuffix(current.Challenge, "-01")
+	if err := b.solve(client, site, challenge, current.DNSProvider); err != nil {
+		return err
+	}
+	cert, err := client.Renew(current)
+	if err != nil {
+		return err
+	}
+	cert.Challenge, cert.DNSProvider = current.Challenge, current.DNSProvider
+	return acme.Save(cert)
+}
+
+func (b legoBackend) revoke(site webserver.Site) error {
+	if system.Simulated("acme", "revoke certificate for "+site.Domain) {
+		return nil
+	}
+	current, err := acme.Load(site.Domain)
+	if err != nil {
+		return err
+	}
+	if current.Challenge == acme.Manual {
+		return fmt.Errorf("certificate for %s was uploaded manually; upload a new one instead", site.Domain)
+	}
+	client, err := b.client(current.Email)
+	if err != nil {
+		return err
+	}
+	return client.Revoke(current)
+}
+
+// certbotBackend shells out to an installed certbot.
+type certbotBackend struct{}
+
+func (certbotBackend) serverArgs() []string {
+	if dir := sslOption(directoryFlag, "ssl.directory"); dir != "" {
+		return []string{"--server", dir}
+	}
+	return nil
+}
+
+func (b certbotBackend) issue(site webserver.Site, opts IssueOptions) (string, string, error) {
+	if opts.Challenge == challengeDNS {
+		return "", "", fmt.Errorf("the dns challenge is only supported by the lego backend")
+	}
+	args := []string{"certbot", "certonly", "--webroot", "-w", site.Webroot(), "--cert-name", site.Domain}
+	for _, name := range opts.names(site) {
+		args = append(args, "-d", name)
+	}
+	if opts.Email != "" {
+		args = append(args, "--email", opts.Email)
+	} else {
+		args = append(args, "--register-unsafely-without-email")
+	}
+	args = append(args, "--agree-tos", "--non-interactive")
+	if _, err := system.Run("sudo", append(args, b.serverArgs()...)...); err != nil {
+		return "", "", err
+	}
+	dir := certbotLiveDir + site.Domain
+	return dir + "/fullchain.pem", dir + "/privkey.pem", nil
+}
+
+func (b certbotBackend) renew(site webserver.Site) error {
+	args := append([]string{"certbot", "renew", "--cert-name", site.Domain, "--non-interactive"}, b.serverArgs()...)
+	_, err := system.Run("sudo", args...)
+	return err
+}
+
+func (b certbotBackend) revoke(site webserver.Site) error {
+	args := append([]string{"certbot", "revoke", "--cert-name", site.Domain, "--non-interactive"}, b.serverArgs()...)
+	_, err := system.Run("sudo", args...)
+	return err
+}
@@ -0,0 +1,174 @@
+/*
+Copyright © 2025 Stackroost CLI
+*/
+package security
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"stackroost-cli/cmd/domain"
+	"stackroost-cli/cmd/internal/acme"
+	"stackroost-cli/cmd/internal/certs"
+	"stackroost-cli/cmd/internal/system"
+)
+
+// certificateEntry is one row of ssl list.
+type certificateEntry struct {
+	certs.Info
+	// InUse names the managed domain whose vhost references the certificate.
+	InUse string `json:"in_use,omitempty"`
+	// Error says why the certificate could not be read; only Path and
+	// Domain are set then.
+	Error string `json:"error,omitempty"`
+}
+
+// certificateDirs are scanned for <domain>/fullchain.pem.
+var certificateDirs = []string{acme.CertDir, certbotLiveDir}
+
+// inventory parses every installed certificate. Certificates that cannot be
+// read or parsed are listed with their error rather than hiding the rest.
+func inventory(now time.Time) ([]certificateEntry, error) {
+	users := vhostReferences()
+	var entries []certificateEntry
+	for _, dir := range certificateDirs {
+		paths, err := certificateFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			name := filepath.Base(filepath.Dir(path))
+			chain, err := readBundle(path)
+			if err != nil {
+				entries = append(entries, certificateEntry{
+					Info:  certs.Info{Path: path, Domain: name},
+					InUse: users[path],
+					Error: err.Error(),
+				})
+				continue
+			}
+			entries = append(entries, certificateEntry{
+				Info:  certs.Describe(path, name, chain[0], now),
+				InUse: users[path],
+			})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].NotAfter.Before(entries[j].NotAfter) })
+	return entries, nil
+}
+
+// certificateFiles returns the <domain>/fullchain.pem files under dir.
+// certbot keeps its live directory readable by root only, so a directory the
+// current user cannot read is searched through sudo.
+func certificateFiles(dir string) ([]string, error) {
+	items, err := system.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if os.IsPermission(err) {
+		res, err := system.Query("sudo", "find", dir, "-mindepth", "2", "-maxdepth", "2", "-name", "fullchain.pem")
+		if err != nil {
+			return nil, err
+		}
+		paths := strings.Fields(res.Stdout)
+		sort.Strings(paths)
+		return paths, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, item := range items {
+		if !item.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, item.Name(), "fullchain.pem")
+		if _, err := system.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// readBundle reads and parses a certificate chain, through sudo if the
+// current user cannot read it.
+func readBundle(path string) ([]*x509.Certificate, error) {
+	data, err := system.ReadFile(path)
+	if os.IsPermission(err) {
+		var res *system.Result
+		if res, err = system.Query("sudo", "cat", path); err == nil {
+			data = []byte(res.Stdout)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return certs.ParseBundle(data)
+}
+
+// vhostReferences maps certificate paths to the managed domain whose live
+// vhost file actually points at them.
+func vhostReferences() map[string]string {
+	refs := map[string]string{}
+	for _, name := range domain.Names() {
+		ws, site, err := domain.Lookup(name)
+		if err != nil || site.CertFile == "" {
+			continue
+		}
+		content, err := system.ReadFile(ws.VhostPath(name))
+		if err == nil && strings.Contains(string(content), site.CertFile) {
+			refs[site.CertFile] = name
+		}
+	}
+	return refs
+}
+
+// parseWindow accepts a Go duration or a number of days such as 30d.
+func parseWindow(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func printCertificates(w io.Writer, entries []certificateEntry, asJSON bool) error {
+	if asJSON {
+		if entries == nil {
+			entries = []certificateEntry{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOMAIN\tSANS\tISSUER\tNOT BEFORE\tNOT AFTER\tDAYS\tKEY\tIN USE")
+	for _, e := range entries {
+		inUse := "-"
+		if e.InUse != "" {
+			inUse = e.InUse
+		}
+		if e.Error != "" {
+			fmt.Fprintf(tw, "%s\t-\tinvalid: %s\t-\t-\t-\t-\t%s\n", e.Domain, e.Error, inUse)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s %d\t%s\n",
+			e.Domain, strings.Join(e.SANs, ","), e.Issuer,
+			e.NotBefore.Format("2006-01-02"), e.NotAfter.Format("2006-01-02"),
+			e.DaysLeft, e.KeyType, e.KeyBits, inUse)
+	}
+	return tw.Flush()
+}
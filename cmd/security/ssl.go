@@ -5,13 +5,17 @@ Copyright © 2025 Stackroost CLI
 package security
 
 import (
+	"bytes"
 	"fmt"
-	"io/ioutil"
+	"os"
+	"time"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
+	"stackroost-cli/cmd/domain"
+	"stackroost-cli/cmd/internal/acme"
+	"stackroost-cli/cmd/internal/certs"
 	"stackroost-cli/cmd/internal/logger"
-	"stackroost-cli/cmd/internal/utils"
+	"stackroost-cli/cmd/internal/system"
 )
 
 // sslCmd represents the ssl command
@@ -25,19 +29,18 @@ var sslIssueCmd = &cobra.Command{
 	Use:   "issue [domain]",
 	Short: "Issue SSL certificate for domain",
 	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		domain := args[0]
-		email, _ := cmd.Flags().GetString("email")
-		root := viper.GetString("domains." + domain + ".root")
-		server := viper.GetString("domains." + domain + ".server")
-		if server == "apache" {
-			utils.RunCommand("sudo", "certbot", "--apache", "-d", domain, "--email", email, "--agree-tos", "--non-interactive")
-		} else {
-			utils.RunCommand("sudo", "certbot", "certonly", "--webroot", "-w", root, "-d", domain, "--email", email, "--agree-tos", "--non-interactive")
+		var opts IssueOptions
+		opts.Email, _ = cmd.Flags().GetString("email")
+		opts.Names, _ = cmd.Flags().GetStringArray("domain")
+		opts.Challenge, _ = cmd.Flags().GetString("challenge")
+		opts.DNSProvider, _ = cmd.Flags().GetString("dns-provider")
+		if err := Issue(domain, opts); err != nil {
+			return err
 		}
-		// Update vhost to include SSL
-		addSSLToVhost(domain, server)
 		fmt.Printf("SSL issued for %s\n", domain)
+		return nil
 	},
 }
 
@@ -45,10 +48,13 @@ var sslRenewCmd = &cobra.Command{
 	Use:   "renew [domain]",
 	Short: "Renew SSL certificate",
 	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		domain := args[0]
-		utils.RunCommand("sudo", "certbot", "renew", "--cert-name", domain)
+		if err := Renew(domain); err != nil {
+			return err
+		}
 		fmt.Printf("SSL renewed for %s\n", domain)
+		return nil
 	},
 }
 
@@ -56,10 +62,13 @@ var sslRevokeCmd = &cobra.Command{
 	Use:   "revoke [domain]",
 	Short: "Revoke SSL certificate",
 	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		domain := args[0]
-		utils.RunCommand("sudo", "certbot", "revoke", "-d", domain)
+		if err := Revoke(domain); err != nil {
+			return err
+		}
 		fmt.Printf("SSL revoked for %s\n", domain)
+		return nil
 	},
 }
 
@@ -67,26 +76,45 @@ var sslUploadCmd = &cobra.Command{
 	Use:   "upload [domain]",
 	Short: "Upload manual SSL certificate",
 	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		domain := args[0]
 		cert, _ := cmd.Flags().GetString("cert")
 		key, _ := cmd.Flags().GetString("key")
-		dir := "/etc/letsencrypt/live/" + domain
-		utils.RunCommand("sudo", "mkdir", "-p", dir)
-		utils.RunCommand("sudo", "cp", cert, dir+"/fullchain.pem")
-		utils.RunCommand("sudo", "cp", key, dir+"/privkey.pem")
-		server := viper.GetString("domains." + domain + ".server")
-		addSSLToVhost(domain, server)
+		if err := Upload(domain, cert, key); err != nil {
+			return err
+		}
 		fmt.Printf("SSL uploaded for %s\n", domain)
+		return nil
 	},
 }
 
 var sslListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List SSL certificates",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("SSL certificates:")
-		utils.RunCommand("sudo", "ls", "/etc/letsencrypt/live/")
+	Long: `List installed certificates with their names, issuer, validity, key and the
+managed domain whose virtual host uses them.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		asJSON, _ := cmd.Flags().GetBool("json")
+		within, _ := cmd.Flags().GetString("expiring-within")
+		now := time.Now()
+		entries, err := inventory(now)
+		if err != nil {
+			return err
+		}
+		if within != "" {
+			window, err := parseWindow(within)
+			if err != nil {
+				return err
+			}
+			var expiring []certificateEntry
+			for _, e := range entries {
+				if e.NotAfter.Before(now.Add(window)) {
+					expiring = append(expiring, e)
+				}
+			}
+			entries = expiring
+		}
+		return printCertificates(os.Stdout, entries, asJSON)
 	},
 }
 
@@ -99,32 +127,108 @@ func AddSSLCmd(root *cobra.Command) {
 	sslCmd.AddCommand(sslUploadCmd)
 	sslCmd.AddCommand(sslListCmd)
 
+	sslCmd.PersistentFlags().StringVar(&backendFlag, "backend", "", "ACME backend: lego (built in, default) or certbot (default from ssl.backend in config)")
+	sslCmd.PersistentFlags().StringVar(&directoryFlag, "directory", "", "ACME directory URL (default from ssl.directory in config, else Let's Encrypt)")
+
 	sslIssueCmd.Flags().String("email", "", "Email for Let's Encrypt")
+	sslIssueCmd.Flags().StringArrayP("domain", "d", nil, "Additional name to include, wildcards allowed with --challenge dns (repeatable)")
+	sslIssueCmd.Flags().String("challenge", "", "ACME challenge: http (webroot, default) or dns")
+	sslIssueCmd.Flags().String("dns-provider", "", "DNS provider for the dns challenge: rfc2136 or exec (default ssl.dns.provider)")
+	sslListCmd.Flags().Bool("json", false, "Print the inventory as JSON")
+	sslListCmd.Flags().String("expiring-within", "", "Only show certificates expiring within this window, e.g. 30d or 72h")
 	sslUploadCmd.Flags().String("cert", "", "Path to certificate file")
 	sslUploadCmd.Flags().String("key", "", "Path to key file")
+	sslUploadCmd.MarkFlagRequired("cert")
+	sslUploadCmd.MarkFlagRequired("key")
+	sslUploadCmd.MarkFlagFilename("cert")
+	sslUploadCmd.MarkFlagFilename("key")
+}
+
+// Issue obtains a certificate for a managed domain, its aliases and any extra
+// names with the configured ACME backend and wires it into the domain's
+// virtual host.
+func Issue(name string, opts IssueOptions) error {
+	_, site, err := domain.Lookup(name)
+	if err != nil {
+		return err
+	}
+	if opts, err = opts.validate(site); err != nil {
+		return err
+	}
+	backend, err := backendNamed(sslOption(backendFlag, "ssl.backend"))
+	if err != nil {
+		return err
+	}
+	certFile, keyFile, err := backend.issue(site, opts)
+	if err != nil {
+		return err
+	}
+	// Update vhost to include SSL
+	return domain.InstallCertificate(name, certFile, keyFile)
 }
 
-func addSSLToVhost(domain, server string) {
-	if server == "apache" {
-		// Certbot handles it
-	} else if server == "nginx" {
-		logger.Info(fmt.Sprintf("Adding SSL configuration to vhost for domain %s", domain))
-		file := fmt.Sprintf("/etc/nginx/sites-available/%s", domain)
-		content, _ := ioutil.ReadFile(file)
-		// Add SSL server block
-		sslBlock := fmt.Sprintf(`
-server {
-    listen 443 ssl;
-    server_name %s;
-    root %s;
-    ssl_certificate /etc/letsencrypt/live/%s/fullchain.pem;
-    ssl_certificate_key /etc/letsencrypt/live/%s/privkey.pem;
-    location / {
-        try_files $uri $uri/ =404;
-    }
-}`, domain, viper.GetString("domains."+domain+".root"), domain, domain)
-		newContent := string(content) + sslBlock
-		ioutil.WriteFile(file, []byte(newContent), 0644)
-		utils.RunCommand("sudo", "systemctl", "reload", "nginx")
+// Renew renews the certificate of a managed domain with the backend that
+// issued it and reloads the web server.
+func Renew(name string) error {
+	ws, site, err := domain.Lookup(name)
+	if err != nil {
+		return err
+	}
+	backend, err := backendFor(site)
+	if err != nil {
+		return err
+	}
+	if err := backend.renew(site); err != nil {
+		return err
 	}
-}
\ No newline at end of file
+	return ws.Reload()
+}
+
+// Revoke revokes the certificate of a managed domain.
+func Revoke(name string) error {
+	_, site, err := domain.Lookup(name)
+	if err != nil {
+		return err
+	}
+	backend, err := backendFor(site)
+	if err != nil {
+		return err
+	}
+	return backend.revoke(site)
+}
+
+// Upload validates a certificate and key supplied by the user, installs them
+// under acme.CertDir with the chain in leaf-to-root order and wires them into
+// the domain's virtual host.
+func Upload(name, certFile, keyFile string) error {
+	_, site, err := domain.Lookup(name)
+	if err != nil {
+		return err
+	}
+	certPEM, err := system.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := system.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	chain, err := certs.Validate(certPEM, keyPEM, append([]string{name}, site.Aliases...), time.Now())
+	if err != nil {
+		return fmt.Errorf("refusing to install %s: %w", certFile, err)
+	}
+	fullChain := certs.EncodeBundle(chain)
+	if bundle, _ := certs.ParseBundle(certPEM); len(bundle) > 1 && !bytes.Equal(fullChain, certs.EncodeBundle(bundle)) {
+		logger.Info("Reordered the certificate chain from leaf to root")
+	}
+	if err := acme.Save(&acme.Certificate{
+		Domain:     name,
+		Challenge:  acme.Manual,
+		FullChain:  fullChain,
+		PrivateKey: keyPEM,
+	}); err != nil {
+		return err
+	}
+	installedCert, installedKey := acme.Paths(name)
+	return domain.InstallCertificate(name, installedCert, installedKey)
+}
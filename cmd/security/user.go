@@ -6,11 +6,11 @@ package security
 
 import (
 	"fmt"
-	"os"
-	"os/exec"
 
 	"github.com/spf13/cobra"
-	"stackroost-cli/cmd/internal/utils"
+	"github.com/spf13/viper"
+	"stackroost-cli/cmd/internal/config"
+	"stackroost-cli/cmd/internal/system"
 )
 
 // userCmd represents the user command
@@ -24,25 +24,27 @@ var userAddCmd = &cobra.Command{
 	Use:   "add [username]",
 	Short: "Add a new user",
 	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		username := args[0]
 		password, _ := cmd.Flags().GetString("password")
-		utils.RunCommand("sudo", "useradd", "-m", username)
-		if password != "" {
-			utils.RunCommand("sudo", "chpasswd", fmt.Sprintf("%s:%s", username, password))
+		if err := AddUser(username, password); err != nil {
+			return err
 		}
 		fmt.Printf("Added user %s\n", username)
+		return nil
 	},
 }
 
 var userListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List users",
-	Run: func(cmd *cobra.Command, args []string) {
-		execCmd := exec.Command("cut", "-d:", "-f1", "/etc/passwd")
-		execCmd.Stdout = os.Stdout
-		execCmd.Stderr = os.Stderr
-		execCmd.Run()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		res, err := system.Query("cut", "-d:", "-f1", "/etc/passwd")
+		if err != nil {
+			return err
+		}
+		fmt.Print(res.Stdout)
+		return nil
 	},
 }
 
@@ -50,10 +52,13 @@ var userPasswdCmd = &cobra.Command{
 	Use:   "passwd [username]",
 	Short: "Change user password",
 	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		username := args[0]
-		utils.RunCommand("sudo", "passwd", username)
+		if _, err := system.Interactive("sudo", "passwd", username); err != nil {
+			return err
+		}
 		fmt.Printf("Password changed for %s\n", username)
+		return nil
 	},
 }
 
@@ -61,10 +66,13 @@ var userRemoveCmd = &cobra.Command{
 	Use:   "remove [username]",
 	Short: "Remove a user",
 	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		username := args[0]
-		utils.RunCommand("sudo", "userdel", "-r", username)
+		if err := RemoveUser(username); err != nil {
+			return err
+		}
 		fmt.Printf("Removed user %s\n", username)
+		return nil
 	},
 }
 
@@ -72,10 +80,13 @@ var userSSHEnableCmd = &cobra.Command{
 	Use:   "ssh-enable [username]",
 	Short: "Enable SSH for user",
 	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		username := args[0]
-		utils.RunCommand("sudo", "usermod", "-aG", "ssh", username)
+		if err := SetSSH(username, true); err != nil {
+			return err
+		}
 		fmt.Printf("SSH enabled for %s\n", username)
+		return nil
 	},
 }
 
@@ -83,10 +94,13 @@ var userSSHDisableCmd = &cobra.Command{
 	Use:   "ssh-disable [username]",
 	Short: "Disable SSH for user",
 	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		username := args[0]
-		utils.RunCommand("sudo", "gpasswd", "-d", username, "ssh")
+		if err := SetSSH(username, false); err != nil {
+			return err
+		}
 		fmt.Printf("SSH disabled for %s\n", username)
+		return nil
 	},
 }
 
@@ -101,4 +115,56 @@ func AddUserCmd(root *cobra.Command) {
 	userCmd.AddCommand(userSSHDisableCmd)
 
 	userAddCmd.Flags().String("password", "", "User password")
-}
\ No newline at end of file
+}
+
+// ManagedUsers lists the users created through stackroost.
+func ManagedUsers() []string {
+	return config.Names("users", "ssh")
+}
+
+// UserSSHEnabled reports whether a managed user was granted SSH access.
+func UserSSHEnabled(username string) bool {
+	return viper.GetBool("users." + username + ".ssh")
+}
+
+// AddUser creates a system user with a home directory and records it in the
+// config.
+func AddUser(username, password string) error {
+	if _, err := system.Run("sudo", "useradd", "-m", username); err != nil {
+		return err
+	}
+	if password != "" {
+		// chpasswd reads user:password pairs from stdin
+		if _, err := system.RunInput([]byte(username+":"+password+"\n"), "sudo", "chpasswd"); err != nil {
+			return err
+		}
+	}
+	viper.Set("users."+username+".ssh", false)
+	return config.Save()
+}
+
+// RemoveUser deletes a system user and its home directory.
+func RemoveUser(username string) error {
+	if _, err := system.Run("sudo", "userdel", "-r", username); err != nil {
+		return err
+	}
+	if err := config.Unset("users." + username); err != nil {
+		return err
+	}
+	return config.Save()
+}
+
+// SetSSH adds the user to or removes it from the ssh group.
+func SetSSH(username string, enabled bool) error {
+	var err error
+	if enabled {
+		_, err = system.Run("sudo", "usermod", "-aG", "ssh", username)
+	} else {
+		_, err = system.Run("sudo", "gpasswd", "-d", username, "ssh")
+	}
+	if err != nil {
+		return err
+	}
+	viper.Set("users."+username+".ssh", enabled)
+	return config.Save()
+}
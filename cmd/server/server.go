@@ -5,14 +5,13 @@ Copyright © 2025 Stackroost CLI
 package server
 
 import (
-	"bufio"
 	"fmt"
-	"os"
-	"os/exec"
-	"strings"
+	"sort"
 
 	"github.com/spf13/cobra"
-	"stackroost-cli/cmd/internal/utils"
+	"stackroost-cli/cmd/internal/distro"
+	"stackroost-cli/cmd/internal/system"
+	"stackroost-cli/cmd/internal/webserver"
 )
 
 // serverCmd represents the server command
@@ -22,59 +21,21 @@ var serverCmd = &cobra.Command{
 	Long:  `Commands for managing web server services including start, stop, reload, and status checks.`,
 }
 
-var servers = map[string]map[string]string{
-	"ubuntu": {
-		"apache": "apache2",
-		"nginx":  "nginx",
-		"caddy":  "caddy",
-	},
-	"centos": {
-		"apache": "httpd",
-		"nginx":  "nginx",
-		"caddy":  "caddy",
-	},
-	"fedora": {
-		"apache": "httpd",
-		"nginx":  "nginx",
-		"caddy":  "caddy",
-	},
-	"debian": {
-		"apache": "apache2",
-		"nginx":  "nginx",
-		"caddy":  "caddy",
-	},
-	"rhel": {
-		"apache": "httpd",
-		"nginx":  "nginx",
-		"caddy":  "caddy",
-	},
-	"sles": {
-		"apache": "apache2",
-		"nginx":  "nginx",
-		"caddy":  "caddy",
-	},
-	"opensuse": {
-		"apache": "apache2",
-		"nginx":  "nginx",
-		"caddy":  "caddy",
-	},
-}
-
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List installed web servers",
-	Run: func(cmd *cobra.Command, args []string) {
-		distro := detectDistro()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		distroServers, err := distro.Services(distro.Detect())
+		if err != nil {
+			return err
+		}
 		fmt.Println("Installed web servers:")
-		if distroServers, ok := servers[distro]; ok {
-			for name, service := range distroServers {
-				if isServiceInstalled(service) {
-					fmt.Printf("- %s (%s)\n", name, service)
-				}
+		for name, service := range distroServers {
+			if isServiceInstalled(service) {
+				fmt.Printf("- %s (%s)\n", name, service)
 			}
-		} else {
-			fmt.Printf("Unsupported distribution: %s\n", distro)
 		}
+		return nil
 	},
 }
 
@@ -82,19 +43,12 @@ var startCmd = &cobra.Command{
 	Use:   "start [server]",
 	Short: "Start a web server",
 	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		distro := detectDistro()
-		server := args[0]
-		if distroServers, ok := servers[distro]; ok {
-			if service, ok := distroServers[server]; ok {
-				utils.RunCommand("sudo", "systemctl", "start", service)
-				fmt.Printf("Started %s\n", server)
-			} else {
-				fmt.Printf("Unknown server: %s\n", server)
-			}
-		} else {
-			fmt.Printf("Unsupported distribution: %s\n", distro)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := controlService("start", args[0]); err != nil {
+			return err
 		}
+		fmt.Printf("Started %s\n", args[0])
+		return nil
 	},
 }
 
@@ -102,19 +56,12 @@ var stopCmd = &cobra.Command{
 	Use:   "stop [server]",
 	Short: "Stop a web server",
 	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		distro := detectDistro()
-		server := args[0]
-		if distroServers, ok := servers[distro]; ok {
-			if service, ok := distroServers[server]; ok {
-				utils.RunCommand("sudo", "systemctl", "stop", service)
-				fmt.Printf("Stopped %s\n", server)
-			} else {
-				fmt.Printf("Unknown server: %s\n", server)
-			}
-		} else {
-			fmt.Printf("Unsupported distribution: %s\n", distro)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := controlService("stop", args[0]); err != nil {
+			return err
 		}
+		fmt.Printf("Stopped %s\n", args[0])
+		return nil
 	},
 }
 
@@ -122,41 +69,51 @@ var reloadCmd = &cobra.Command{
 	Use:   "reload [server]",
 	Short: "Reload a web server configuration",
 	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		distro := detectDistro()
-		server := args[0]
-		if distroServers, ok := servers[distro]; ok {
-			if service, ok := distroServers[server]; ok {
-				utils.RunCommand("sudo", "systemctl", "reload", service)
-				fmt.Printf("Reloaded %s\n", server)
-			} else {
-				fmt.Printf("Unknown server: %s\n", server)
-			}
-		} else {
-			fmt.Printf("Unsupported distribution: %s\n", distro)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := controlService("reload", args[0]); err != nil {
+			return err
 		}
+		fmt.Printf("Reloaded %s\n", args[0])
+		return nil
 	},
 }
 
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Show status of web servers",
-	Run: func(cmd *cobra.Command, args []string) {
-		distro := detectDistro()
-		if distroServers, ok := servers[distro]; ok {
-			for name, service := range distroServers {
-				if isServiceInstalled(service) {
-					fmt.Printf("%s:\n", name)
-					cmd := exec.Command("systemctl", "status", service, "--no-pager", "-l")
-					cmd.Stdout = os.Stdout
-					cmd.Stderr = os.Stderr
-					cmd.Run()
-					fmt.Println()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		distroServers, err := distro.Services(distro.Detect())
+		if err != nil {
+			return err
+		}
+		for name, service := range distroServers {
+			if isServiceInstalled(service) {
+				fmt.Printf("%s:\n", name)
+				if _, err := system.Interactive("systemctl", "status", service, "--no-pager", "-l"); err != nil {
+					return err
 				}
+				fmt.Println()
 			}
-		} else {
-			fmt.Printf("Unsupported distribution: %s\n", distro)
 		}
+		return nil
+	},
+}
+
+var testCmd = &cobra.Command{
+	Use:   "test [server...]",
+	Short: "Check the configuration of web servers",
+	Long: `Runs the native configuration check of the named web servers, or of every
+running one, and with --reload reloads each server whose check passed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		names := args
+		if len(names) == 0 {
+			var err error
+			if names, err = running(); err != nil {
+				return err
+			}
+		}
+		reload, _ := cmd.Flags().GetBool("reload")
+		return Test(names, reload)
 	},
 }
 
@@ -168,29 +125,64 @@ func AddServerCmd(root *cobra.Command) {
 	serverCmd.AddCommand(stopCmd)
 	serverCmd.AddCommand(reloadCmd)
 	serverCmd.AddCommand(statusCmd)
+	serverCmd.AddCommand(testCmd)
+
+	testCmd.Flags().Bool("reload", false, "reload each server whose configuration check passed")
+}
+
+// Test runs the configuration check of each named server and, if reload is
+// set, reloads it. It stops at the first server that fails.
+func Test(names []string, reload bool) error {
+	for _, name := range names {
+		ws, err := webserver.Get(name)
+		if err != nil {
+			return err
+		}
+		if err := ws.ConfigTest(); err != nil {
+			return fmt.Errorf("%s configuration test failed: %w", name, err)
+		}
+		fmt.Printf("%s configuration is valid\n", name)
+		if !reload {
+			continue
+		}
+		if err := ws.Reload(); err != nil {
+			return fmt.Errorf("failed to reload %s: %w", name, err)
+		}
+		fmt.Printf("Reloaded %s\n", name)
+	}
+	return nil
 }
 
-func detectDistro() string {
-	file, err := os.Open("/etc/os-release")
+// running returns the web servers whose service is active.
+func running() ([]string, error) {
+	distroServers, err := distro.Services(distro.Detect())
 	if err != nil {
-		return "unknown"
+		return nil, err
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "ID=") {
-			id := strings.TrimPrefix(line, "ID=")
-			id = strings.Trim(id, "\"")
-			return id
+	var names []string
+	for name, service := range distroServers {
+		if isServiceInstalled(service) {
+			names = append(names, name)
 		}
 	}
-	return "unknown"
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no web server is running")
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// controlService runs a systemctl action against the distro's unit for server.
+func controlService(action, server string) error {
+	service, err := distro.Service(server)
+	if err != nil {
+		return err
+	}
+	_, err = system.Run("sudo", "systemctl", action, service)
+	return err
 }
 
 func isServiceInstalled(service string) bool {
-	cmd := exec.Command("systemctl", "is-active", service)
-	err := cmd.Run()
+	_, err := system.Query("systemctl", "is-active", service)
 	return err == nil
 }
\ No newline at end of file